@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// requireAdmin rejects the requests without the admin bearer token, without
+// an admin token set the admin endpoints are disabled instead of open.
+func (a *App) requireAdmin(c *gin.Context) {
+	switch {
+	case a.adminToken == "":
+		abortWithError(c, http.StatusNotFound, "admin_disabled", "the admin endpoints require an admin token")
+	case !a.isStaff(c):
+		abortWithError(c, http.StatusUnauthorized, "unauthorized", "invalid admin token")
+	}
+}
+
+// isStaff returns if the request carries the admin bearer token, without an
+// admin token set nobody is.
+func (a *App) isStaff(c *gin.Context) bool {
+	if a.adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1
+}
+
+// MetricsSummary is a snapshot of the health of the process
+type MetricsSummary struct {
+	Queues            int64   `json:"queues"`
+	Waiting           int64   `json:"waiting"`
+	WaitingPeople     int64   `json:"waiting_people"`
+	Requests          float64 `json:"requests"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Goroutines        int     `json:"goroutines"`
+	DBOpenConnections int     `json:"db_open_connections"`
+	UptimeSeconds     int64   `json:"uptime_seconds"`
+}
+
+// getMetricsSummary aggregates the process wide metrics, the requests per
+// second are averaged over the uptime.
+func (a *App) getMetricsSummary(c *gin.Context) {
+	var s MetricsSummary
+	err := a.db.QueryRowx(`SELECT (SELECT COUNT(*) FROM queue), COUNT(*), COALESCE(SUM(groupsize), 0)
+		FROM reservation WHERE status='waiting'`).Scan(&s.Queues, &s.Waiting, &s.WaitingPeople)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	uptime := time.Since(a.startedAt)
+	s.Requests = a.metrics.get("cola_http_requests_total")
+	if uptime > 0 {
+		s.RequestsPerSecond = s.Requests / uptime.Seconds()
+	}
+	s.Goroutines = runtime.NumGoroutine()
+	s.DBOpenConnections = a.db.Stats().OpenConnections
+	s.UptimeSeconds = int64(uptime / time.Second)
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// pruneEmptyQueues deletes the queues without waiting reservations, with
+// older_than only the queues created before that duration are deleted.
+func (a *App) pruneEmptyQueues(c *gin.Context) {
+	cutoff := a.now().UTC()
+	if v := c.Query("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			abortWithError(c, http.StatusBadRequest, "invalid_older_than", "older_than must be a positive duration, e.g. 24h")
+			return
+		}
+		cutoff = cutoff.Add(-d)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	pruned := []Queue{}
+	err = tx.Select(&pruned, `SELECT * FROM queue WHERE created_at <= $1 AND
+		NOT EXISTS (SELECT 1 FROM reservation WHERE reservation.queueid = queue.id AND reservation.status='waiting') ORDER BY id ASC`, cutoff)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, q := range pruned {
+		if _, err := tx.Exec("DELETE FROM queue WHERE id=$1", q.ID); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if err := a.audit(tx, q.ID, 0, "queue_deleted", gin.H{"pruned": true}); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, q := range pruned {
+		a.queueCache.remove(q.ID)
+	}
+	c.IndentedJSON(http.StatusOK, pruned)
+}
+
+// resequence renumbers the waiting reservations of the queue to a contiguous
+// 1..N sequence in serving order, auditing the moves, it returns the number
+// of reservations whose position changed.
+func (a *App) resequence(tx *sqlx.Tx, queueID string) (int, error) {
+	var reservations []struct {
+		ID       int64 `json:"id"`
+		QueueID  int64 `json:"queueid"`
+		Position int64 `json:"position"`
+	}
+	err := tx.Select(&reservations, "SELECT id, queueid, position FROM reservation WHERE queueid=$1 AND status='waiting' ORDER BY "+servingOrder, queueID)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for i, r := range reservations {
+		pos := int64(i + 1)
+		if r.Position == pos {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE reservation SET position=$1 WHERE id=$2", pos, r.ID); err != nil {
+			return 0, err
+		}
+		if err := a.audit(tx, r.QueueID, r.ID, "moved", gin.H{"from": r.Position, "to": pos}); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// repairPositions renumbers the waiting reservations of the queue, fixing
+// duplicated or missing positions.
+func (a *App) repairPositions(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	changed, err := a.resequence(tx, c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changed": changed})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPruneEmptyQueues(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"empty_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"busy_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	now = now.Add(time.Hour)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"new_empty_queue"}`)
+
+	// the new empty queue was created less than 30 minutes ago
+	w := doAdminRequest(testApp, "POST", "/api/v1/admin/queues/prune-empty?older_than=30m", "")
+	var pruned []Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &pruned); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(pruned) != 1 || pruned[0].Name != "empty_queue" {
+		t.Fatalf("expected only empty_queue to be pruned, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue", "")
+	var queues []Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &queues); err != nil {
+		t.Fatal(err)
+	}
+	if len(queues) != 2 || queues[0].Name != "busy_queue" || queues[1].Name != "new_empty_queue" {
+		t.Fatalf("unexpected remaining queues: %s", w.Body.String())
+	}
+
+	w = doAdminRequest(testApp, "POST", "/api/v1/admin/queues/prune-empty?older_than=yesterday", "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid older_than, got %d", w.Code)
+	}
+}
+
+func TestRepairPositions(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"broken_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	// duplicated and gapped positions
+	testApp.db.MustExec("UPDATE reservation SET position=2 WHERE id=1")
+	testApp.db.MustExec("UPDATE reservation SET position=7 WHERE id=3")
+	testApp.db.MustExec("UPDATE reservation SET position=9 WHERE id=4")
+
+	w := doAdminRequest(testApp, "POST", "/api/v1/admin/queue/1/repair-positions", "")
+	var result struct {
+		Changed int `json:"changed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	// 1 wins the tie and goes back to 1, 2 keeps its position, 3 and 4 close the gaps
+	if w.Code != http.StatusOK || result.Changed != 3 {
+		t.Fatalf("expected 3 changes, got %d %s", w.Code, w.Body.String())
+	}
+
+	var positions []struct {
+		ID       int64 `json:"id"`
+		Position int64 `json:"position"`
+	}
+	if err := testApp.db.Select(&positions, "SELECT id, position FROM reservation ORDER BY position"); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range positions {
+		if p.Position != int64(i+1) || p.ID != int64(i+1) {
+			t.Fatalf("unexpected positions after repair: %+v", positions)
+		}
+	}
+
+	// nothing to do on a valid sequence
+	w = doAdminRequest(testApp, "POST", "/api/v1/admin/queue/1/repair-positions", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed != 0 {
+		t.Fatalf("expected no changes, got %d", result.Changed)
+	}
+}
+
+func TestMetricsSummary(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"summary_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"summary_queue2"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":3}`)
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_2","phone":"222222222"}`)
+
+	w := doAdminRequest(testApp, "GET", "/api/v1/admin/metrics-summary", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"queues", "waiting", "waiting_people", "requests", "requests_per_second", "goroutines", "db_open_connections", "uptime_seconds"} {
+		if _, ok := fields[f]; !ok {
+			t.Fatalf("expected field %s in %s", f, w.Body.String())
+		}
+	}
+	var s MetricsSummary
+	json.Unmarshal(w.Body.Bytes(), &s)
+	if s.Queues != 2 || s.Waiting != 2 || s.WaitingPeople != 4 || s.Requests != 5 || s.RequestsPerSecond <= 0 ||
+		s.Goroutines < 1 || s.DBOpenConnections < 1 || s.UptimeSeconds < 0 {
+		t.Fatalf("unexpected summary %s", w.Body.String())
+	}
+
+	if w := doRequest(testApp, "GET", "/api/v1/admin/metrics-summary", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the admin token, got %d", w.Code)
+	}
+
+	// without an admin token configured the admin endpoints are disabled
+	testApp.adminToken = ""
+	if w := doRequest(testApp, "GET", "/api/v1/admin/metrics-summary", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without an admin token configured, got %d", w.Code)
+	}
+}
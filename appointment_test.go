@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAppointmentMode(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"clinic_queue","strategy":"appointment"}`)
+	for _, body := range []string{
+		`{"name":"appointment_11","phone":"111111111","scheduled_at":"2022-01-01T12:00:00+01:00"}`,
+		`{"name":"walkin_customer","phone":"222222222"}`,
+		`{"name":"appointment_1030","phone":"333333333","scheduled_at":"2022-01-01T10:30:00Z"}`,
+	} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=3", "")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"appointment_1030", "appointment_11", "walkin_customer"}
+	if len(rs) != len(want) {
+		t.Fatalf("expected %v, got %s", want, w.Body.String())
+	}
+	for i := range want {
+		if rs[i].Name != want[i] {
+			t.Fatalf("expected the appointments first by time, got %s at %d", rs[i].Name, i)
+		}
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+	var s ReservationStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.SecondsUntilAppointment == nil || *s.SecondsUntilAppointment != 3600 || s.EstimatedWaitSeconds != 3600 ||
+		s.Message != "Your appointment is in 60 minutes." {
+		t.Fatalf("expected the time until the appointment, got %s", w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/2/status", "")
+	s = ReservationStatus{}
+	json.Unmarshal(w.Body.Bytes(), &s)
+	if s.SecondsUntilAppointment != nil || s.PartiesAhead != 2 {
+		t.Fatalf("expected the walk-in to report the parties ahead, got %s", w.Body.String())
+	}
+
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	var served ServedReservation
+	json.Unmarshal(w.Body.Bytes(), &served)
+	if served.Name != "appointment_1030" {
+		t.Fatalf("expected the earliest appointment to be served, got %s", w.Body.String())
+	}
+
+	// the walk-in queues ignore the scheduled times
+	doRequest(testApp, "PATCH", "/api/v1/queue/1", `{"strategy":"fifo"}`)
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/peek", "")
+	rs = nil
+	json.Unmarshal(w.Body.Bytes(), &rs)
+	if len(rs) != 1 || rs[0].Name != "appointment_11" {
+		t.Fatalf("expected the serving order by position, got %s", w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+	s = ReservationStatus{}
+	json.Unmarshal(w.Body.Bytes(), &s)
+	if s.SecondsUntilAppointment != nil || s.EstimatedWaitSeconds != 0 {
+		t.Fatalf("expected no appointment time without appointment mode, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attachmentList is a list of references to files stored elsewhere, URLs or
+// storage keys, stored as a JSON array since URLs may have commas
+type attachmentList []string
+
+func (l attachmentList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *attachmentList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("can not scan %T into an attachment list", src)
+	}
+	*l = nil
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), (*[]string)(l))
+}
+
+// attachmentError is an attachment list over the limits or an invalid
+// reference, Limit is set for the limits
+type attachmentError struct {
+	Code    string
+	Message string
+	Limit   int
+}
+
+// checkAttachments returns why the attachments can't be stored, they must
+// be http or https URLs or storage keys without spaces, up to the configured
+// count and length, a non positive maximum is unlimited.
+func (a *App) checkAttachments(l attachmentList) *attachmentError {
+	if a.maxAttachments > 0 && len(l) > a.maxAttachments {
+		return &attachmentError{
+			Code:    "too_many_attachments",
+			Message: fmt.Sprintf("attachments must be at most %d", a.maxAttachments),
+			Limit:   a.maxAttachments,
+		}
+	}
+	for _, ref := range l {
+		if a.maxAttachmentLength > 0 && utf8.RuneCountInString(ref) > a.maxAttachmentLength {
+			return &attachmentError{
+				Code:    "attachment_too_long",
+				Message: fmt.Sprintf("attachments must be at most %d characters", a.maxAttachmentLength),
+				Limit:   a.maxAttachmentLength,
+			}
+		}
+		if ref == "" || strings.IndexFunc(ref, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return &attachmentError{Code: "invalid_attachment", Message: fmt.Sprintf("invalid attachment %q", ref)}
+		}
+		if strings.Contains(ref, "://") {
+			u, err := url.Parse(ref)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+				return &attachmentError{Code: "invalid_attachment", Message: fmt.Sprintf("attachment %q must be an http or https URL without credentials", ref)}
+			}
+		}
+	}
+	return nil
+}
+
+// abortWithAttachmentError replies 400 with the attachments field
+func abortWithAttachmentError(c *gin.Context, e *attachmentError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+		Message: e.Message,
+		Code:    e.Code,
+		Fields:  []string{"attachments"},
+		Limit:   e.Limit,
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.maxAttachments = 2
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"photo_queue"}`)
+	get := func() Reservation {
+		t.Helper()
+		var r Reservation
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation",
+		`{"name":"customer_1","phone":"111111111","attachments":["https://cdn.example.com/walkin.jpg?a=1,2","receipts/2022/42.pdf"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	if r := get(); strings.Join(r.Attachments, " ") != "https://cdn.example.com/walkin.jpg?a=1,2 receipts/2022/42.pdf" {
+		t.Fatalf("expected the attachments to round-trip, got %v", r.Attachments)
+	}
+
+	// kept when not given, replaced when given
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"111111111"}`)
+	if r := get(); len(r.Attachments) != 2 {
+		t.Fatalf("expected the attachments kept, got %v", r.Attachments)
+	}
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"111111111","attachments":["receipts/43.pdf"]}`)
+	if r := get(); strings.Join(r.Attachments, " ") != "receipts/43.pdf" {
+		t.Fatalf("expected the attachments replaced, got %v", r.Attachments)
+	}
+
+	for body, code := range map[string]string{
+		`{"name":"customer_2","phone":"222222222","attachments":["a.jpg","b.jpg","c.jpg"]}`:            "too_many_attachments",
+		`{"name":"customer_2","phone":"222222222","attachments":["ftp://example.com/a.jpg"]}`:          "invalid_attachment",
+		`{"name":"customer_2","phone":"222222222","attachments":["my photo.jpg"]}`:                     "invalid_attachment",
+		`{"name":"customer_2","phone":"222222222","attachments":["` + strings.Repeat("a", 501) + `"]}`: "attachment_too_long",
+	} {
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+		if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != code || e.Fields[0] != "attachments" {
+			t.Fatalf("expected 400 %s, got %d %s", code, w.Code, w.Body.String())
+		}
+	}
+	w = doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"111111111","attachments":["a.jpg","b.jpg","c.jpg"]}`)
+	if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "too_many_attachments" || e.Limit != 2 {
+		t.Fatalf("expected 400 over the cap updating, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditEntry records a change done to a reservation, the changes to the
+// queue itself have reservation 0.
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	QueueID       int64     `json:"queueid"`
+	ReservationID int64     `json:"reservationid"`
+	Action        string    `json:"action"`
+	Detail        string    `json:"detail"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// audit records the action within the transaction of the change, detail is
+// stored as JSON. The entry is also written to the audit sink, if any, once
+// the transaction is committed with a.commit.
+func (a *App) audit(tx *sqlx.Tx, queueID, reservationID int64, action string, detail interface{}) error {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	e := AuditEntry{
+		QueueID:       queueID,
+		ReservationID: reservationID,
+		Action:        action,
+		Detail:        string(b),
+		CreatedAt:     a.now().UTC(),
+	}
+	res, err := tx.NamedExec(`INSERT INTO audit (queueid, reservationid, action, detail, created_at)
+		VALUES (:queueid, :reservationid, :action, :detail, :created_at)`, e)
+	if err != nil {
+		return err
+	}
+	if e.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	if a.auditSink != nil {
+		a.auditMu.Lock()
+		if a.auditPending == nil {
+			a.auditPending = map[*sqlx.Tx][]AuditEntry{}
+		}
+		a.auditPending[tx] = append(a.auditPending[tx], e)
+		a.auditMu.Unlock()
+	}
+	return nil
+}
+
+// takeAudit returns and forgets the entries audited within the transaction
+func (a *App) takeAudit(tx *sqlx.Tx) []AuditEntry {
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	entries := a.auditPending[tx]
+	delete(a.auditPending, tx)
+	return entries
+}
+
+// commit commits the transaction and then writes its audit entries to the
+// sink, the changes rolled back never reach it.
+func (a *App) commit(tx *sqlx.Tx) error {
+	err := tx.Commit()
+	entries := a.takeAudit(tx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		a.auditSink(e)
+	}
+	return nil
+}
+
+// rollback rolls back the transaction discarding its audit entries, it does
+// nothing once committed.
+func (a *App) rollback(tx *sqlx.Tx) {
+	tx.Rollback()
+	a.takeAudit(tx)
+}
+
+// newAuditSink returns where the audit entries are written as JSON lines
+// besides the database: stdout, a file:<path> appended to or an http(s)
+// URL they are posted to.
+func newAuditSink(spec string) (func(AuditEntry), error) {
+	switch {
+	case spec == "stdout":
+		return newAuditWriter(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return newAuditWriter(f), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return newAuditWebhook(spec), nil
+	}
+	return nil, fmt.Errorf("unknown audit sink %q, it must be stdout, file:<path> or an http(s) URL", spec)
+}
+
+// newAuditWriter writes the entries to w, one JSON object per line
+func newAuditWriter(w io.Writer) func(AuditEntry) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(e AuditEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(e); err != nil {
+			log.Printf("Error writing the audit entry %d: %v", e.ID, err)
+		}
+	}
+}
+
+// newAuditWebhook posts the entries to url, asynchronously like the events
+// so a slow receiver doesn't hold the changes.
+func newAuditWebhook(url string) func(AuditEntry) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(e AuditEntry) {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("Error encoding the audit entry %d: %v", e.ID, err)
+			return
+		}
+		go func() {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Error posting the audit entry %d: %v", e.ID, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
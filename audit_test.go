@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditFileSink(t *testing.T) {
+	testApp := newTestApp(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := newAuditSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.auditSink = sink
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/1/status", `{"status":"cancelled"}`)
+
+	readSink := func() []AuditEntry {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var entries []AuditEntry
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("expected a JSON line, got %q: %v", scanner.Text(), err)
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+	entries := readSink()
+	if len(entries) != 3 || entries[0].Action != "queue_created" || entries[0].ReservationID != 0 {
+		t.Fatalf("expected the queue creation first in the sink, got %+v", entries)
+	}
+	if entries[1].Action != "created" || entries[1].ReservationID != 1 || entries[1].QueueID != 1 || entries[1].ID == 0 {
+		t.Fatalf("expected the reservation creation after it, got %+v", entries[1])
+	}
+	if entries[2].Action != "cancelled" {
+		t.Fatalf("expected the cancellation after it, got %+v", entries[2])
+	}
+
+	// the changes rolled back don't reach the sink
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/bulk-status", `{"ids":[2,99],"status":"cancelled"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown reservation, got %d %s", w.Code, w.Body.String())
+	}
+	doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation/1", "")
+	doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation", "")
+	doRequest(testApp, "DELETE", "/api/v1/queue/1", "")
+	var actions []string
+	for _, e := range readSink()[3:] {
+		actions = append(actions, e.Action)
+	}
+	if want := []string{"created", "deleted", "cleared", "queue_deleted"}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("expected the entries %v, got %v", want, actions)
+	}
+	// the same as in the database
+	var stored []AuditEntry
+	if err := testApp.db.Select(&stored, "SELECT * FROM audit ORDER BY id"); err != nil || len(stored) != len(entries)+len(actions) {
+		t.Fatalf("expected the %d entries of the database in the sink, got %d: %v", len(stored), len(entries)+len(actions), err)
+	}
+
+	if _, err := newAuditSink("syslog"); err == nil {
+		t.Fatalf("expected an unknown sink to be rejected")
+	}
+}
+
+func TestAuditSinkServed(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := newAuditSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.auditSink = sink
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/hold", "")
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/release", "")
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/next", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status serving: %d %s", w.Code, w.Body.String())
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/3/notify-at", `{"position":1,"webhook":"http://example.com/hook"}`)
+	doAdminRequest(testApp, "PUT", "/api/v1/admin/queue/1/hook", `{"secret":"0123456789abcdef"}`)
+	doAdminRequest(testApp, "DELETE", "/api/v1/admin/queue/1/hook", "")
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var actions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", scanner.Text(), err)
+		}
+		if e.Action == StatusServed && e.ReservationID != 1 {
+			t.Fatalf("expected the first reservation served, got %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	want := []string{"queue_created", "created", "created", "created", "held", "released", StatusServed, "trigger_created", "hook_configured", "hook_deleted"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("expected the entries %v, got %v", want, actions)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// bindJSON decodes and validates the request body into obj, in strict mode
+// the fields not defined in obj are rejected. It replies with the error and
+// returns false on failure.
+func (a *App) bindJSON(c *gin.Context, obj interface{}) bool {
+	body, err := c.GetRawData()
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return false
+	}
+	if a.strictBinding {
+		if unknown := unknownFields(body, obj); len(unknown) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+				Message: "unknown fields: " + strings.Join(unknown, ", "),
+				Code:    "unknown_fields",
+				Fields:  unknown,
+			})
+			return false
+		}
+	}
+	if err := binding.JSON.BindBody(body, obj); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return false
+	}
+	return true
+}
+
+// unknownFields returns the sorted keys of the JSON object in body that
+// don't match any json tag of the struct obj points to.
+func unknownFields(body []byte, obj interface{}) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// not an object, the decoder reports it
+		return nil
+	}
+	known := map[string]bool{}
+	jsonFields(reflect.TypeOf(obj).Elem(), known)
+	var unknown []string
+	for name := range fields {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// jsonFields adds the JSON names of the fields of the struct type t to
+// names, including the ones of its embedded structs.
+func jsonFields(t reflect.Type, names map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, names)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = true
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStrictBinding(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"strict_queue"}`)
+
+	// lenient by default
+	body := `{"name":"customer_1","phone":"111111111","groupSizee":4}`
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected unknown fields to be ignored by default, got %d %s", w.Code, w.Body.String())
+	}
+
+	testApp.strictBinding = true
+	body = `{"name":"customer_2","phone":"222222222","groupSizee":4}`
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+	e, ok := decodeError(w)
+	if w.Code != http.StatusBadRequest || !ok || e.Code != "unknown_fields" || len(e.Fields) != 1 || e.Fields[0] != "groupSizee" {
+		t.Fatalf("expected 400 listing groupSizee, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "POST", "/api/v1/queue", `{"name":"strict_queue2","colour":"red","maximum":2}`)
+	e, ok = decodeError(w)
+	if w.Code != http.StatusBadRequest || !ok || len(e.Fields) != 2 || e.Fields[0] != "colour" || e.Fields[1] != "maximum" {
+		t.Fatalf("expected 400 listing colour and maximum, got %d %s", w.Code, w.Body.String())
+	}
+
+	body = `{"name":"customer_3","phone":"333333333","groupsize":4}`
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected known fields to be accepted in strict mode, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BulkStatusRequest moves many waiting reservations to a final status at
+// once, e.g. the no-shows after a long closure.
+type BulkStatusRequest struct {
+	IDs    []int64 `json:"ids" binding:"required,min=1,dive,min=1"`
+	Status string  `json:"status" binding:"required,oneof=cancelled no_show"`
+}
+
+// bulkStatus takes the reservations out of the queue with the status, in
+// one transaction so either all of them or none change, and renumbers the
+// remaining ones.
+func (a *App) bulkStatus(c *gin.Context) {
+	id := c.Param("id")
+	var req BulkStatusRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+	queueID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_queue_id", "invalid queue id")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	seen := map[int64]bool{}
+	ids := []int64{}
+	for _, rsvp := range req.IDs {
+		if seen[rsvp] {
+			continue
+		}
+		seen[rsvp] = true
+		var r Reservation
+		err := tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", queueID, rsvp)
+		if err != nil {
+			// only the waiting reservations can change their status
+			var status string
+			err := tx.Get(&status, "SELECT status FROM reservation WHERE queueid=$1 AND id=$2", queueID, rsvp)
+			if err == nil {
+				abortWithError(c, http.StatusConflict, "invalid_transition", fmt.Sprintf("reservation %d is already %s", rsvp, status))
+				return
+			}
+			if err != sql.ErrNoRows {
+				abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+			var served bool
+			if err := tx.Get(&served, "SELECT EXISTS (SELECT 1 FROM served WHERE queueid=$1 AND reservationid=$2)", queueID, rsvp); err != nil {
+				abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+			if served {
+				abortWithError(c, http.StatusConflict, "invalid_transition", fmt.Sprintf("reservation %d was already served", rsvp))
+				return
+			}
+			abortWithError(c, http.StatusNotFound, "reservation_not_found", fmt.Sprintf("reservation %d not found", rsvp))
+			return
+		}
+		if _, err := tx.Exec("UPDATE reservation SET status=$1 WHERE id=$2", req.Status, r.ID); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		err = a.audit(tx, r.QueueID, r.ID, req.Status, gin.H{"from": "waiting", "position": r.Position, "bulk": true})
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		ids = append(ids, r.ID)
+	}
+	if _, err := a.resequence(tx, id); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, rsvp := range ids {
+		a.emit(Event{Type: EventDeleted, QueueID: queueID, ReservationID: rsvp})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": req.Status, "updated": ids})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestBulkStatus(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for i := 1; i <= 6; i++ {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000"}`, i, i))
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve", "")
+
+	// the whole request fails if any transition isn't allowed
+	for body, code := range map[string]int{
+		`{"ids":[2,1],"status":"cancelled"}`: http.StatusConflict,
+		`{"ids":[2,9],"status":"cancelled"}`: http.StatusNotFound,
+		`{"ids":[2],"status":"served"}`:      http.StatusBadRequest,
+		`{"ids":[],"status":"cancelled"}`:    http.StatusBadRequest,
+	} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/bulk-status", body); w.Code != code {
+			t.Fatalf("expected %d for %s, got %d %s", code, body, w.Code, w.Body.String())
+		}
+	}
+	var n int
+	if err := testApp.db.Get(&n, "SELECT COUNT(*) FROM reservation WHERE status='waiting'"); err != nil || n != 5 {
+		t.Fatalf("expected the failed requests not to change anything, got %d %v", n, err)
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/bulk-status", `{"ids":[2,4,4],"status":"no_show"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"customer_3", "customer_5", "customer_6"}
+	if len(rs) != len(expected) {
+		t.Fatalf("unexpected reservations left: %s", w.Body.String())
+	}
+	for i, r := range rs {
+		if r.Name != expected[i] || r.Position != int64(i+1) {
+			t.Fatalf("expected %s at position %d, got %s at %d", expected[i], i+1, r.Name, r.Position)
+		}
+	}
+
+	var audits []AuditEntry
+	if err := testApp.db.Select(&audits, "SELECT * FROM audit WHERE action='no_show' ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 2 || audits[0].ReservationID != 2 || audits[1].ReservationID != 4 {
+		t.Fatalf("expected an audit entry per reservation, got %+v", audits)
+	}
+}
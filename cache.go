@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+)
+
+// queueCache is a LRU cache of the queue rows keyed by id, every write to
+// the queue table has to invalidate the queues it modifies once committed.
+type queueCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[int64]*list.Element
+	// gens counts the invalidations of each queue, so a row read before
+	// one isn't added after it
+	gens map[int64]uint64
+}
+
+// newQueueCache returns a cache of up to size queues, 0 disables it
+func newQueueCache(size int) *queueCache {
+	return &queueCache{
+		size:  size,
+		ll:    list.New(),
+		items: map[int64]*list.Element{},
+		gens:  map[int64]uint64{},
+	}
+}
+
+func (qc *queueCache) get(id int64) (Queue, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	e, ok := qc.items[id]
+	if !ok {
+		return Queue{}, false
+	}
+	qc.ll.MoveToFront(e)
+	return e.Value.(Queue), true
+}
+
+// generation returns the number of invalidations of the queue, to be taken
+// before reading the row to fill the cache with.
+func (qc *queueCache) generation(id int64) uint64 {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	return qc.gens[id]
+}
+
+// fill adds the queue read at the generation gen, unless it was invalidated
+// since then and it may be stale.
+func (qc *queueCache) fill(q Queue, gen uint64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if qc.size <= 0 || qc.gens[q.ID] != gen {
+		return
+	}
+	if e, ok := qc.items[q.ID]; ok {
+		e.Value = q
+		qc.ll.MoveToFront(e)
+		return
+	}
+	qc.items[q.ID] = qc.ll.PushFront(q)
+	if qc.ll.Len() > qc.size {
+		oldest := qc.ll.Back()
+		qc.ll.Remove(oldest)
+		delete(qc.items, oldest.Value.(Queue).ID)
+	}
+}
+
+func (qc *queueCache) remove(id int64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	qc.gens[id]++
+	if e, ok := qc.items[id]; ok {
+		qc.ll.Remove(e)
+		delete(qc.items, id)
+	}
+}
+
+// invalidate removes the queue with the id from the request, if valid
+func (qc *queueCache) invalidate(id string) {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		qc.remove(n)
+	}
+}
+
+// getQueue returns the queue, from the cache if present, or sql.ErrNoRows
+// if it doesn't exist.
+func (a *App) getQueue(id string) (Queue, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		if q, ok := a.queueCache.get(n); ok {
+			return q, nil
+		}
+	}
+	gen := a.queueCache.generation(n)
+	var q Queue
+	if err := a.db.Get(&q, "SELECT * FROM queue WHERE id=$1", id); err != nil {
+		return q, err
+	}
+	a.queueCache.fill(q, gen)
+	return q, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestQueueCacheInvalidation(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.queueCache = newQueueCache(2)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"cached_queue","ticket_prefix":"A"}`)
+
+	get := func() (Queue, int) {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1", "")
+		var q Queue
+		json.Unmarshal(w.Body.Bytes(), &q)
+		return q, w.Code
+	}
+	if q, _ := get(); q.TicketPrefix != "A" {
+		t.Fatalf("unexpected queue %+v", q)
+	}
+	if _, ok := testApp.queueCache.get(1); !ok {
+		t.Fatalf("expected the queue to be cached")
+	}
+
+	doRequest(testApp, "PATCH", "/api/v1/queue/1", `{"ticket_prefix":"B"}`)
+	if q, _ := get(); q.TicketPrefix != "B" {
+		t.Fatalf("expected the update to be visible, got %+v", q)
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	var r Reservation
+	json.Unmarshal(w.Body.Bytes(), &r)
+	if r.Ticket != "B001" {
+		t.Fatalf("expected the ticket to use the new prefix, got %q", r.Ticket)
+	}
+
+	doRequest(testApp, "DELETE", "/api/v1/queue/1?force=true", "")
+	if _, code := get(); code != http.StatusNotFound {
+		t.Fatalf("expected a deleted queue not to be served from the cache, got %d", code)
+	}
+}
+
+func TestQueueCacheEviction(t *testing.T) {
+	qc := newQueueCache(2)
+	qc.fill(Queue{ID: 1}, 0)
+	qc.fill(Queue{ID: 2}, 0)
+	qc.get(1)
+	qc.fill(Queue{ID: 3}, 0)
+	if _, ok := qc.get(2); ok {
+		t.Fatalf("expected the least recently used queue to be evicted")
+	}
+	for _, id := range []int64{1, 3} {
+		if _, ok := qc.get(id); !ok {
+			t.Fatalf("expected queue %d to be cached", id)
+		}
+	}
+
+	disabled := newQueueCache(0)
+	disabled.fill(Queue{ID: 1}, 0)
+	if _, ok := disabled.get(1); ok {
+		t.Fatalf("expected a zero size cache to be disabled")
+	}
+}
+
+func TestQueueCacheStaleFill(t *testing.T) {
+	qc := newQueueCache(2)
+	// read before the update, added after its invalidation
+	gen := qc.generation(1)
+	qc.invalidate("1")
+	qc.fill(Queue{ID: 1, QueueConfig: QueueConfig{TicketPrefix: "A"}}, gen)
+	if _, ok := qc.get(1); ok {
+		t.Fatalf("expected the queue read before the invalidation not to be cached")
+	}
+	qc.fill(Queue{ID: 1, QueueConfig: QueueConfig{TicketPrefix: "B"}}, qc.generation(1))
+	if q, ok := qc.get(1); !ok || q.TicketPrefix != "B" {
+		t.Fatalf("expected the queue read after the invalidation to be cached, got %+v", q)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckinRequest is the body of a check-in, the token comes from the QR
+// code of the reservation
+type CheckinRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// signCheckin returns the signature of the check-in token of a reservation,
+// the seq is signed too so the token doesn't apply to a later reservation
+// reusing the id.
+func (a *App) signCheckin(rsvp, seq, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(a.checkinSecret))
+	mac.Write([]byte("checkin\n" + strconv.FormatInt(rsvp, 10) + "\n" + strconv.FormatInt(seq, 10) + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkinToken returns the check-in token of the reservation, valid for
+// checkinTTL, or empty if the check-ins are disabled.
+func (a *App) checkinToken(r Reservation) string {
+	if a.checkinSecret == "" {
+		return ""
+	}
+	expires := a.now().Add(a.checkinTTL).Unix()
+	return strconv.FormatInt(r.ID, 10) + "." + strconv.FormatInt(expires, 10) + "." + a.signCheckin(r.ID, r.Seq, expires)
+}
+
+// checkin confirms the arrival of the party of the reservation in the token,
+// checking in again keeps the first time.
+func (a *App) checkin(c *gin.Context) {
+	if a.checkinSecret == "" {
+		abortWithError(c, http.StatusNotFound, "not_found", "check-ins are disabled")
+		return
+	}
+	var req CheckinRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+	parts := strings.Split(req.Token, ".")
+	if len(parts) != 3 {
+		abortWithError(c, http.StatusUnauthorized, "invalid_signature", "invalid check-in token")
+		return
+	}
+	rsvp, err1 := strconv.ParseInt(parts[0], 10, 64)
+	expires, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		abortWithError(c, http.StatusUnauthorized, "invalid_signature", "invalid check-in token")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var r Reservation
+	err := a.db.Get(&r, "SELECT * FROM reservation WHERE id=$1 AND status='waiting'", rsvp)
+	if err != nil && err != sql.ErrNoRows {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// a token of a reservation that left the queue doesn't verify either
+	if err == sql.ErrNoRows || !hmac.Equal([]byte(parts[2]), []byte(a.signCheckin(rsvp, r.Seq, expires))) {
+		abortWithError(c, http.StatusUnauthorized, "invalid_signature", "invalid check-in token")
+		return
+	}
+	if a.now().Unix() > expires {
+		abortWithError(c, http.StatusUnauthorized, "token_expired", "the check-in token has expired")
+		return
+	}
+	if r.CheckedInAt == nil {
+		now := a.now().UTC()
+		tx, err := a.db.Beginx()
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		defer a.rollback(tx)
+		if _, err := tx.Exec("UPDATE reservation SET checked_in_at=$1 WHERE id=$2", now, r.ID); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if err := a.audit(tx, r.QueueID, r.ID, "checked_in", gin.H{"via": "token"}); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if err := a.commit(tx); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		r.CheckedInAt = &now
+		a.emit(Event{Type: EventUpdated, QueueID: r.QueueID, ReservationID: r.ID})
+	}
+	c.JSON(http.StatusOK, gin.H{"id": r.ID, "queueid": r.QueueID, "checked_in_at": r.CheckedInAt})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckin(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	testApp.checkinSecret = "s3cr3t"
+	testApp.checkinTTL = time.Hour
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"checkin_queue"}`)
+	token := func(body string) string {
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.CheckinToken == "" {
+			t.Fatalf("expected a check-in token, got %s", w.Body.String())
+		}
+		return r.CheckinToken
+	}
+	valid := token(`{"name":"customer_1","phone":"111111111"}`)
+	expired := token(`{"name":"customer_2","phone":"222222222"}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/checkin", `{"token":"`+valid+`"}`)
+	var checked struct {
+		ID          int64      `json:"id"`
+		CheckedInAt *time.Time `json:"checked_in_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &checked); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || checked.ID != 1 || checked.CheckedInAt == nil || !checked.CheckedInAt.Equal(now) {
+		t.Fatalf("unexpected check-in: %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.CheckedInAt == nil || r.CheckinToken != "" {
+		t.Fatalf("expected the reservation checked in without its token, got %s", w.Body.String())
+	}
+
+	now = now.Add(2 * time.Hour)
+	w = doRequest(testApp, "POST", "/api/v1/checkin", `{"token":"`+expired+`"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "token_expired" {
+		t.Fatalf("expected the expired token to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	// moving the expiry breaks the signature
+	parts := strings.Split(expired, ".")
+	tampered := parts[0] + ".9999999999." + parts[2]
+	w = doRequest(testApp, "POST", "/api/v1/checkin", `{"token":"`+tampered+`"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "invalid_signature" {
+		t.Fatalf("expected the tampered token to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/checkin", `{"token":"garbage"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a malformed token to be rejected, got %d", w.Code)
+	}
+}
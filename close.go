@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationQueueClosed tells the parties still waiting the queue closed
+const NotificationQueueClosed = "queue_closed"
+
+// CloseRequest is the optional body closing a queue, Cancel removes the
+// parties still waiting instead of keeping them until the queue reopens
+type CloseRequest struct {
+	Cancel bool `json:"cancel"`
+}
+
+// closeQueue pauses the queue and notifies, only once, the parties still
+// waiting. The queue reopens when unpaused.
+func (a *App) closeQueue(c *gin.Context) {
+	id := c.Param("id")
+	var req CloseRequest
+	if c.Request.ContentLength != 0 && !a.bindJSON(c, &req) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var q Queue
+	err = tx.Get(&q, "SELECT * FROM queue WHERE id=$1", id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if q.ClosedAt != nil {
+		abortWithError(c, http.StatusConflict, "queue_closed", "the queue is already closed")
+		return
+	}
+	now := a.now().UTC()
+	if _, err := tx.Exec("UPDATE queue SET paused=1, closed_at=$1 WHERE id=$2", now, q.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var rs []Reservation
+	if err := tx.Select(&rs, selectReservations, q.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var notifications []Notification
+	for _, r := range rs {
+		if req.Cancel {
+			if _, err := tx.Exec("UPDATE reservation SET status=$1 WHERE id=$2", StatusCancelled, r.ID); err != nil {
+				abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+			err := a.audit(tx, r.QueueID, r.ID, "cancelled", gin.H{"from": "waiting", "position": r.Position, "reason": NotificationQueueClosed})
+			if err != nil {
+				abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+		}
+		if err := a.audit(tx, r.QueueID, r.ID, "notified", map[string]string{"kind": NotificationQueueClosed}); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		lang := a.i18n.fallback
+		if r.Locale != "" {
+			lang = r.Locale
+		}
+		notifications = append(notifications, Notification{
+			Kind:          NotificationQueueClosed,
+			QueueID:       r.QueueID,
+			ReservationID: r.ID,
+			Name:          r.Name,
+			Phone:         r.Phone,
+			Locale:        lang,
+			Message:       a.i18n.message(lang, "notification.queue_closed", nil),
+			Time:          now,
+		})
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.queueCache.invalidate(id)
+	for _, n := range notifications {
+		if err := a.notifier.Notify(context.Background(), n); err != nil {
+			log.Printf("Error notifying reservation %d: %v", n.ReservationID, err)
+		}
+		if req.Cancel {
+			a.emit(Event{Type: EventDeleted, QueueID: n.QueueID, ReservationID: n.ReservationID})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": q.ID, "closed_at": now, "notified": len(notifications), "cancelled": req.Cancel})
+}
+
+// closedReservation reports if the reservation left its queue cancelled by
+// the closure, the notifications audited after it are skipped.
+func (a *App) closedReservation(queueID, rsvp string) (bool, error) {
+	var last AuditEntry
+	err := a.db.Get(&last, `SELECT * FROM audit
+		WHERE queueid=$1 AND reservationid=$2 AND action != 'notified' ORDER BY id DESC LIMIT 1`, queueID, rsvp)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil || last.Action != "cancelled" {
+		return false, err
+	}
+	var detail struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(last.Detail), &detail); err != nil {
+		return false, err
+	}
+	return detail.Reason == NotificationQueueClosed, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCloseQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	fake := &fakeNotifier{}
+	testApp.notifier = fake
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"kept_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"cancel_queue"}`)
+	for _, queue := range []string{"1", "2"} {
+		doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", `{"name":"customer_1","phone":"111111111"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", `{"name":"customer_2","phone":"222222222","locale":"es"}`)
+	}
+
+	t.Run("keeping the parties", func(t *testing.T) {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/close", ""); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 closing the queue, got %d %s", w.Code, w.Body.String())
+		}
+		sent := fake.sent()
+		if len(sent) != 2 || sent[0].Kind != NotificationQueueClosed || sent[0].ReservationID != 1 || sent[1].ReservationID != 2 ||
+			sent[0].Message != "The queue has closed, sorry for the inconvenience." || sent[1].Locale != "es" {
+			t.Fatalf("expected both parties notified, got %+v", sent)
+		}
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+		var s ReservationStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || !s.QueueClosed || s.Message != "The queue is closed." {
+			t.Fatalf("expected the status to report the closure, got %d %s", w.Code, w.Body.String())
+		}
+		// paused
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`); w.Code != http.StatusForbidden {
+			t.Fatalf("expected the closed queue to reject reservations, got %d", w.Code)
+		}
+		// only once
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/close", ""); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 closing it again, got %d", w.Code)
+		}
+		if len(fake.sent()) != 2 {
+			t.Fatalf("expected no new notifications, got %+v", fake.sent())
+		}
+		// reopened by unpausing
+		doRequest(testApp, "PATCH", "/api/v1/queue/1", `{"paused":false}`)
+		w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+		var reopened ReservationStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &reopened); err != nil || reopened.QueueClosed {
+			t.Fatalf("expected the queue reopened, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("cancelling the parties", func(t *testing.T) {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/2/close", `{"cancel":true}`); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 closing the queue, got %d %s", w.Code, w.Body.String())
+		}
+		if sent := fake.sent(); len(sent) != 4 || sent[2].ReservationID != 3 || sent[3].ReservationID != 4 {
+			t.Fatalf("expected both parties notified, got %+v", sent)
+		}
+		var cancelled int
+		if err := testApp.db.Get(&cancelled, "SELECT COUNT(*) FROM reservation WHERE queueid=2 AND status='cancelled'"); err != nil || cancelled != 2 {
+			t.Fatalf("expected the reservations cancelled, got %d: %v", cancelled, err)
+		}
+		w := doRequest(testApp, "GET", "/api/v1/queue/2/reservation/4/status", "")
+		if e, _ := decodeError(w); w.Code != http.StatusGone || e.Code != "queue_closed" || e.Message != "The queue is closed." {
+			t.Fatalf("expected the status to report the closure, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	if w := doRequest(testApp, "POST", "/api/v1/queue/42/close", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 closing a missing queue, got %d", w.Code)
+	}
+}
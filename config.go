@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueueConfig holds the settings of a queue
+type QueueConfig struct {
+	// SLASeconds is the target maximum wait, 0 disables it
+	SLASeconds int64 `json:"sla_seconds" binding:"min=0"`
+	// TicketPrefix identifies the queue in the printed tickets, e.g. A015
+	TicketPrefix string `json:"ticket_prefix" binding:"omitempty,max=4,alphanum"`
+	// RateLimit is the maximum number of reservations per minute, 0 is unlimited
+	RateLimit int64 `json:"rate_limit" binding:"min=0"`
+	// NotifyLeadSeconds notifies the parties when their estimated wait
+	// drops to it, 0 disables it
+	NotifyLeadSeconds int64 `json:"notify_lead_seconds" binding:"min=0"`
+	// Capacity is the maximum number of waiting parties, or people with
+	// CapacityBy people, null is unlimited
+	Capacity   *int64 `json:"capacity" binding:"omitempty,min=1"`
+	CapacityBy string `json:"capacity_by" binding:"omitempty,oneof=parties people"`
+	// Paused queues don't accept new reservations
+	Paused bool `json:"paused"`
+	// RequireConfirmation requires the confirmation code of the reservations
+	// to serve them
+	RequireConfirmation bool `json:"require_confirmation"`
+	// Strategy selects the next party to serve, fifo or appointment to
+	// serve first the appointments by their scheduled time
+	Strategy string `json:"strategy" binding:"omitempty,oneof=fifo appointment"`
+	// StartNumber is the number of the first ticket of the queue
+	StartNumber int64 `json:"start_number" binding:"min=0"`
+	// OpensAt and ClosesAt are the HH:MM local times the queue accepts
+	// reservations between, empty is always open
+	OpensAt  string `json:"opens_at" binding:"omitempty,datetime=15:04"`
+	ClosesAt string `json:"closes_at" binding:"omitempty,datetime=15:04"`
+	// MinGroupSize and MaxGroupSize limit the size of the parties, 0 is no limit
+	MinGroupSize int64 `json:"min_group_size" binding:"min=0"`
+	MaxGroupSize int64 `json:"max_group_size" binding:"min=0"`
+	// Color and Description label the queue in the dashboards, the color is
+	// a hex code like #1e90ff
+	Color       string `json:"color" binding:"omitempty,hexcolor"`
+	Description string `json:"description" binding:"max=200"`
+	// Category groups the queues of a venue in sections, e.g. Food
+	Category string `json:"category" binding:"max=50"`
+	// PositionBandSize reports to the customers the band of positions they
+	// are in, e.g. 6-10, instead of the exact one, 0 disables it
+	PositionBandSize int64 `json:"position_band_size" binding:"min=0"`
+	// RequiredFields are the reservation fields that must be given to join
+	// the queue, by default only the phone
+	RequiredFields fieldList `json:"required_fields" binding:"omitempty,dive,oneof=name phone groupsize email"`
+}
+
+// reservationFields are the reservation fields a queue can require, in the
+// order they are reported
+var reservationFields = []string{"name", "phone", "groupsize", "email"}
+
+// fieldList is a list of field names stored comma separated
+type fieldList []string
+
+func (l fieldList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+func (l *fieldList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("can not scan %T into a field list", src)
+	}
+	*l = fieldList{}
+	if s != "" {
+		*l = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// requires returns if the field is in the list
+func (l fieldList) requires(field string) bool {
+	for _, f := range l {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// setDefaults fills the settings left empty
+func (cfg *QueueConfig) setDefaults() {
+	if cfg.Strategy == "" {
+		cfg.Strategy = "fifo"
+	}
+	if cfg.CapacityBy == "" {
+		cfg.CapacityBy = "parties"
+	}
+	if cfg.StartNumber == 0 {
+		cfg.StartNumber = 1
+	}
+	if cfg.RequiredFields == nil {
+		cfg.RequiredFields = fieldList{"phone"}
+	}
+}
+
+// missingFields returns the required fields the reservation doesn't have
+func (cfg *QueueConfig) missingFields(r Reservation) []string {
+	given := map[string]bool{
+		"name":      r.Name != "",
+		"phone":     r.Phone != "",
+		"groupsize": r.GroupSize != 0,
+		"email":     r.Email != "",
+	}
+	var missing []string
+	for _, f := range reservationFields {
+		if cfg.RequiredFields.requires(f) && !given[f] {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// validate checks the settings depending on each other, it returns the
+// problem found or empty.
+func (cfg *QueueConfig) validate() string {
+	if cfg.MaxGroupSize > 0 && cfg.MinGroupSize > cfg.MaxGroupSize {
+		return "min_group_size can not be greater than max_group_size"
+	}
+	return ""
+}
+
+// checkGroupSize returns why a party of the size can't join, or empty
+func (cfg *QueueConfig) checkGroupSize(size int64) string {
+	if cfg.MinGroupSize > 0 && size < cfg.MinGroupSize {
+		return fmt.Sprintf("the parties must be of at least %d people", cfg.MinGroupSize)
+	}
+	if cfg.MaxGroupSize > 0 && size > cfg.MaxGroupSize {
+		return fmt.Sprintf("the parties must be of at most %d people", cfg.MaxGroupSize)
+	}
+	return ""
+}
+
+// openAt returns if the queue accepts reservations at the time, the
+// opening hours can span midnight, e.g. from 20:00 to 02:00.
+func (cfg *QueueConfig) openAt(t time.Time) bool {
+	now := t.Format("15:04")
+	opens, closes := cfg.OpensAt, cfg.ClosesAt
+	switch {
+	case opens == "" && closes == "":
+		return true
+	case closes == "":
+		return now >= opens
+	case opens == "":
+		return now < closes
+	case opens <= closes:
+		return now >= opens && now < closes
+	default:
+		return now >= opens || now < closes
+	}
+}
+
+func (a *App) getQueueConfig(c *gin.Context) {
+	q, err := a.getQueue(c.Param("id"))
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, q.QueueConfig)
+}
+
+// putQueueConfig replaces all the settings of the queue at once, the
+// settings not present are reset to their defaults.
+func (a *App) putQueueConfig(c *gin.Context) {
+	id := c.Param("id")
+	var cfg QueueConfig
+	if !a.bindJSON(c, &cfg) {
+		return
+	}
+	if msg := cfg.validate(); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+	cfg.setDefaults()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	update := struct {
+		QueueConfig
+		ID string `json:"id"`
+	}{cfg, id}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	res, err := tx.NamedExec(`UPDATE queue SET sla_seconds=:sla_seconds, ticket_prefix=:ticket_prefix, rate_limit=:rate_limit,
+		notify_lead_seconds=:notify_lead_seconds, capacity=:capacity, capacity_by=:capacity_by, paused=:paused,
+		closed_at=CASE WHEN :paused THEN closed_at END, require_confirmation=:require_confirmation,
+		strategy=:strategy, start_number=:start_number, opens_at=:opens_at, closes_at=:closes_at,
+		min_group_size=:min_group_size, max_group_size=:max_group_size, color=:color, description=:description,
+		category=:category, position_band_size=:position_band_size, required_fields=:required_fields WHERE id=:id`, update)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	queueID, _ := strconv.ParseInt(id, 10, 64)
+	if err := a.audit(tx, queueID, 0, "queue_updated", cfg); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	err = a.commit(tx)
+	a.queueCache.invalidate(id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, cfg)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueConfigRoundTrip(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"kiosk_queue","ticket_prefix":"K","rate_limit":5}`)
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/config", "")
+	var cfg QueueConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || cfg.TicketPrefix != "K" || cfg.RateLimit != 5 || cfg.Strategy != "fifo" || cfg.StartNumber != 1 {
+		t.Fatalf("unexpected default config %d %s", w.Code, w.Body.String())
+	}
+
+	capacity := int64(20)
+	want := QueueConfig{
+		SLASeconds:   900,
+		TicketPrefix: "B",
+		Capacity:     &capacity,
+		CapacityBy:   "parties",
+		Paused:       true,
+		Strategy:     "fifo",
+		StartNumber:  100,
+		OpensAt:      "09:00",
+		ClosesAt:     "21:30",
+		MinGroupSize: 1,
+		MaxGroupSize: 8,
+		// the default
+		RequiredFields: fieldList{"phone"},
+	}
+	body, _ := json.Marshal(want)
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/1/config", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/config", "")
+	var got QueueConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// the settings not sent are reset
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	for _, invalid := range []string{
+		`{"opens_at":"9am"}`,
+		`{"strategy":"random"}`,
+		`{"min_group_size":4,"max_group_size":2}`,
+	} {
+		if w := doRequest(testApp, "PUT", "/api/v1/queue/1/config", invalid); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", invalid, w.Code)
+		}
+	}
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/2/config", `{}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown queue, got %d", w.Code)
+	}
+}
+
+func TestQueueConfigEnforced(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 22, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"night_queue","start_number":50,"opens_at":"20:00","closes_at":"02:00","max_group_size":4}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	var r Reservation
+	json.Unmarshal(w.Body.Bytes(), &r)
+	if w.Code != http.StatusCreated || r.Number != 50 {
+		t.Fatalf("expected the first ticket to be 50, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":6}`)
+	if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_group_size" {
+		t.Fatalf("expected a too large party to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	now = time.Date(2022, 1, 2, 3, 0, 0, 0, time.UTC)
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_closed" {
+		t.Fatalf("expected a closed queue to reject reservations, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestQueueColor(t *testing.T) {
+	testApp := newTestApp(t)
+	w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"colored_queue","color":"#1e90ff","description":"Bar waitlist"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1", "")
+	var q Queue
+	json.Unmarshal(w.Body.Bytes(), &q)
+	if q.Color != "#1e90ff" || q.Description != "Bar waitlist" {
+		t.Fatalf("expected the color and description to be stored, got %s", w.Body.String())
+	}
+	if w := doRequest(testApp, "PATCH", "/api/v1/queue/1", `{"color":"#ABC"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected a short hex color to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+
+	for method, url := range map[string]string{
+		"POST":  "/api/v1/queue",
+		"PATCH": "/api/v1/queue/1",
+		"PUT":   "/api/v1/queue/1/config",
+	} {
+		w := doRequest(testApp, method, url, `{"name":"invalid_colored","color":"blue"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for an invalid color on %s %s, got %d", method, url, w.Code)
+		}
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/config", "")
+	var cfg QueueConfig
+	json.Unmarshal(w.Body.Bytes(), &cfg)
+	if cfg.Color != "#ABC" {
+		t.Fatalf("expected the invalid colors not to be stored, got %q", cfg.Color)
+	}
+}
+
+func TestRequiredFields(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"bar_queue1"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"online_orders","required_fields":["name"]}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"delivery_queue","required_fields":["name","phone","email"]}`)
+
+	missing := func(queue, body string, fields ...string) {
+		t.Helper()
+		w := doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", body)
+		e, _ := decodeError(w)
+		if w.Code != http.StatusBadRequest || e.Code != "missing_required_fields" || !reflect.DeepEqual(e.Fields, fields) {
+			t.Fatalf("expected %s to miss %v in queue %s, got %d %s", body, fields, queue, w.Code, w.Body.String())
+		}
+	}
+	created := func(queue, body string) {
+		t.Helper()
+		if w := doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", body); w.Code != http.StatusCreated {
+			t.Fatalf("expected %s to join queue %s, got %d %s", body, queue, w.Code, w.Body.String())
+		}
+	}
+
+	// the phone is required by default
+	missing("1", `{"name":"customer_1"}`, "phone")
+	created("1", `{"phone":"111111111"}`)
+
+	// only the name, many parties can join without phone
+	missing("2", `{"phone":"111111111"}`, "name")
+	created("2", `{"name":"customer_1"}`)
+	created("2", `{"name":"customer_2"}`)
+
+	missing("3", `{"groupsize":2}`, "name", "phone", "email")
+	missing("3", `{"name":"customer_1","phone":"111111111"}`, "email")
+	if w := doRequest(testApp, "POST", "/api/v1/queue/3/reservation", `{"name":"customer_1","phone":"111111111","email":"nope"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid email, got %d %s", w.Code, w.Body.String())
+	}
+	created("3", `{"name":"customer_1","phone":"111111111","email":"customer@example.com"}`)
+	w := doRequest(testApp, "GET", "/api/v1/queue/3/reservation/4", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Email != "customer@example.com" {
+		t.Fatalf("expected the email to be stored, got %s", w.Body.String())
+	}
+	// the updates can't clear the required fields either, they are kept
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/3/reservation/4", `{"name":"customer_1"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the update to keep the phone, got %d %s", w.Code, w.Body.String())
+	}
+	if err := testApp.db.Get(&r, "SELECT * FROM reservation WHERE id=4"); err != nil || r.Phone != "111111111" {
+		t.Fatalf("expected the required phone to be kept, got %q %v", r.Phone, err)
+	}
+
+	if w := doRequest(testApp, "PATCH", "/api/v1/queue/2", `{"required_fields":["age"]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown field, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "PATCH", "/api/v1/queue/2", `{"required_fields":["groupsize"]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"groupsize"`) {
+		t.Fatalf("expected the required fields to be updated, got %d %s", w.Code, w.Body.String())
+	}
+	missing("2", `{"name":"customer_3"}`, "groupsize")
+	created("2", `{"groupsize":3}`)
+}
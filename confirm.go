@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// errInvalidConfirmation is returned serving a reservation of a queue that
+// requires confirmation without its code
+var errInvalidConfirmation = errors.New("invalid confirmation code")
+
+// newConfirmationCode returns a random 4 digits code, short enough to be
+// read aloud at the counter.
+func newConfirmationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%04d", n.Int64()), nil
+}
+
+// checkConfirmation returns errInvalidConfirmation if the queue requires
+// confirmation and the code doesn't match the one of the reservation.
+func checkConfirmation(q Queue, r Reservation, code string) error {
+	if !q.RequireConfirmation {
+		return nil
+	}
+	if r.ConfirmationCode == "" || subtle.ConstantTimeCompare([]byte(code), []byte(r.ConfirmationCode)) != 1 {
+		return errInvalidConfirmation
+	}
+	return nil
+}
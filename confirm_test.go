@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConfirmationCode(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"confirm_queue","require_confirmation":true}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"trusting_queue"}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	if len(r.ConfirmationCode) != 4 {
+		t.Fatalf("expected a 4 digits confirmation code, got %q", r.ConfirmationCode)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", ""); strings.Contains(w.Body.String(), "confirmation_code") {
+		t.Fatalf("expected the confirmation code to be hidden, got %s", w.Body.String())
+	}
+
+	wrong := "0000"
+	if r.ConfirmationCode == wrong {
+		wrong = "9999"
+	}
+	for _, url := range []string{"/api/v1/queue/1/reservation/1/serve", "/api/v1/queue/1/reservation/1/serve?code=" + wrong} {
+		w = doRequest(testApp, "POST", url, "")
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "invalid_confirmation_code" {
+			t.Fatalf("expected a missing or wrong code to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+	}
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve?code="+r.ConfirmationCode, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the right code to serve the reservation, got %d %s", w.Code, w.Body.String())
+	}
+
+	// not required by default
+	if w := doRequest(testApp, "POST", "/api/v1/queue/2/reservation/2/serve", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected serving without code, got %d %s", w.Code, w.Body.String())
+	}
+}
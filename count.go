@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReservationCounts are the figures lobby displays poll
+type ReservationCounts struct {
+	Waiting       int64 `json:"waiting"`
+	PeopleWaiting int64 `json:"people_waiting"`
+	ServedToday   int64 `json:"served_today"`
+}
+
+// countReservations returns the counts of the queue in the body and in the
+// headers, HEAD requests get only the headers.
+func (a *App) countReservations(c *gin.Context) {
+	id := c.Param("id")
+	var counts ReservationCounts
+	err := a.db.QueryRowx("SELECT COUNT(*), COALESCE(SUM(groupsize), 0) FROM reservation WHERE queueid=$1 AND status='waiting'", id).
+		Scan(&counts.Waiting, &counts.PeopleWaiting)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	now := a.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).UTC()
+	err = a.db.Get(&counts.ServedToday, "SELECT COUNT(*) FROM served WHERE queueid=$1 AND served_at >= $2", id, today)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.Header("X-Queue-Waiting", strconv.FormatInt(counts.Waiting, 10))
+	c.Header("X-Queue-People-Waiting", strconv.FormatInt(counts.PeopleWaiting, 10))
+	c.Header("X-Queue-Served-Today", strconv.FormatInt(counts.ServedToday, 10))
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, counts)
+}
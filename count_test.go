@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCountReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lobby_queue"}`)
+	for i, size := range []int{2, 4, 1, 3} {
+		phone := strconv.Itoa(111111111 * (i + 1))
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`","groupsize":`+strconv.Itoa(size)+`}`)
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve", "")
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var all []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	var people int64
+	for _, r := range all {
+		people += r.GroupSize
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/count", "")
+	var counts ReservationCounts
+	if err := json.Unmarshal(w.Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || counts.Waiting != int64(len(all)) || counts.PeopleWaiting != people || counts.ServedToday != 1 {
+		t.Fatalf("expected %d waiting and %d people, got %d %s", len(all), people, w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "HEAD", "/api/v1/queue/1/reservation/count", "")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("X-Queue-Waiting") != "3" ||
+		w.Header().Get("X-Queue-People-Waiting") != "8" || w.Header().Get("X-Queue-Served-Today") != "1" {
+		t.Fatalf("unexpected HEAD response: %d %v", w.Code, w.Header())
+	}
+
+	// served yesterday
+	now = now.Add(24 * time.Hour)
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/count", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+	if counts.ServedToday != 0 {
+		t.Fatalf("expected none served today, got %d", counts.ServedToday)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DashboardQueue is the summary of a queue in the dashboard
+type DashboardQueue struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	Category      string `json:"category"`
+	Color         string `json:"color,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Paused        bool   `json:"paused"`
+	Waiting       int64  `json:"waiting"`
+	PeopleWaiting int64  `json:"people_waiting"`
+}
+
+// DashboardCategory groups the queues of a category, empty for the queues
+// without one
+type DashboardCategory struct {
+	Category      string           `json:"category"`
+	Waiting       int64            `json:"waiting"`
+	PeopleWaiting int64            `json:"people_waiting"`
+	Queues        []DashboardQueue `json:"queues"`
+}
+
+// getDashboard summarizes the queues grouped by category, ordered by name
+// with the queues without category last.
+func (a *App) getDashboard(c *gin.Context) {
+	var queues []DashboardQueue
+	err := a.db.Select(&queues, `SELECT queue.id, queue.name, queue.category, queue.color, queue.description, queue.paused,
+		COUNT(reservation.id) AS waiting, COALESCE(SUM(reservation.groupsize), 0) AS people_waiting
+		FROM queue LEFT JOIN reservation ON reservation.queueid = queue.id AND reservation.status = 'waiting'
+		GROUP BY queue.id ORDER BY queue.category = '' ASC, queue.category ASC, queue.id ASC`)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	categories := []DashboardCategory{}
+	for _, q := range queues {
+		if n := len(categories); n == 0 || categories[n-1].Category != q.Category {
+			categories = append(categories, DashboardCategory{Category: q.Category})
+		}
+		cat := &categories[len(categories)-1]
+		cat.Waiting += q.Waiting
+		cat.PeopleWaiting += q.PeopleWaiting
+		cat.Queues = append(cat.Queues, q)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"categories": categories})
+}
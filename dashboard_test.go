@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboardCategories(t *testing.T) {
+	testApp := newTestApp(t)
+	for _, body := range []string{
+		`{"name":"burger_stand","category":"Food"}`,
+		`{"name":"roller_coaster","category":"Rides"}`,
+		`{"name":"lost_and_found"}`,
+		`{"name":"pizza_stand","category":"Food"}`,
+	} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue", body); w.Code != http.StatusCreated {
+			t.Fatalf("unexpected status creating %s: %d %s", body, w.Code, w.Body.String())
+		}
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/4/reservation", `{"name":"customer_2","phone":"222222222","groupsize":3}`)
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_3","phone":"333333333"}`)
+
+	w := doRequest(testApp, "GET", "/api/v1/dashboard", "")
+	var dashboard struct {
+		Categories []DashboardCategory `json:"categories"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	type group struct {
+		category     string
+		people       int64
+		queues       string
+		waitingFirst int64
+	}
+	expected := []group{
+		{"Food", 5, "burger_stand,pizza_stand", 1},
+		{"Rides", 1, "roller_coaster", 1},
+		{"", 0, "lost_and_found", 0},
+	}
+	if len(dashboard.Categories) != len(expected) {
+		t.Fatalf("unexpected dashboard: %s", w.Body.String())
+	}
+	for i, e := range expected {
+		cat := dashboard.Categories[i]
+		names := []string{}
+		for _, q := range cat.Queues {
+			names = append(names, q.Name)
+		}
+		if cat.Category != e.category || cat.PeopleWaiting != e.people || strings.Join(names, ",") != e.queues ||
+			cat.Queues[0].Waiting != e.waitingFirst {
+			t.Fatalf("unexpected category %d, expected %+v: %s", i, e, w.Body.String())
+		}
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue?category=Food", "")
+	var queues []Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &queues); err != nil {
+		t.Fatal(err)
+	}
+	if len(queues) != 2 || queues[0].Name != "burger_stand" || queues[1].Name != "pizza_stand" {
+		t.Fatalf("unexpected queues of the category: %s", w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue?category=", "")
+	queues = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &queues); err != nil {
+		t.Fatal(err)
+	}
+	if len(queues) != 1 || queues[0].Name != "lost_and_found" {
+		t.Fatalf("unexpected queues without category: %s", w.Body.String())
+	}
+
+	long := strings.Repeat("x", 51)
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"long_category","category":"`+long+`"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a long category, got %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prepareDatabasePath expands a leading ~ and resolves the database path,
+// creating its parent directory with perm if it doesn't exist. Memory
+// databases are returned untouched, file: URIs keep their parameters.
+func prepareDatabasePath(dbname string, perm os.FileMode) (string, error) {
+	if dbname == "" || dbname == ":memory:" || strings.Contains(dbname, "mode=memory") || strings.HasPrefix(dbname, "file::memory:") {
+		return dbname, nil
+	}
+	prefix, path, query := "", dbname, ""
+	if strings.HasPrefix(path, "file:") {
+		prefix = "file:"
+		path = strings.TrimPrefix(path, "file:")
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("can not expand the database path %s: %w", dbname, err)
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("can not resolve the database path %s: %w", dbname, err)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return "", fmt.Errorf("can not create the database directory %s: %w", dir, err)
+	}
+	return prefix + path + query, nil
+}
+
+// withDatabaseKey adds the encryption key to the database DSN, it fails if
+// the driver can't encrypt. No key leaves the DSN untouched.
+func withDatabaseKey(dbname, key string) (string, error) {
+	if key == "" {
+		return dbname, nil
+	}
+	if !encryptionSupported {
+		return "", errors.New("the database key requires a build with the sqlcipher tag")
+	}
+	sep := "?"
+	if strings.Contains(dbname, "?") {
+		sep = "&"
+	}
+	return dbname + sep + "_pragma_key=" + url.QueryEscape(key), nil
+}
+
+// withConnectionPragmas adds to the database DSN the pragmas the driver
+// runs on every new connection: the foreign keys, the write-ahead log, so
+// the reads don't wait for the writes, and how long a connection waits for
+// a locked database before failing with SQLITE_BUSY. Memory databases keep
+// their own journal.
+func withConnectionPragmas(dsn string, busyTimeout time.Duration) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_foreign_keys=1&_journal_mode=WAL&_busy_timeout=%d", dsn, sep, busyTimeout.Milliseconds())
+}
+
+// isUniqueViolation reports whether err is a UNIQUE constraint failure, the
+// message is matched so it works with both the sqlite and sqlcipher drivers.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDatabaseInNewDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data", "nested")
+	dbname := filepath.Join(dir, "cola.db")
+
+	testApp := NewApp(dbname)
+	defer testApp.db.Close()
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("expected the directory %s to be created: %v", dir, err)
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"nested_queue"}`); w.Code != 201 {
+		t.Fatalf("unexpected status creating a queue: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(dbname); err != nil {
+		t.Fatalf("expected the database file %s: %v", dbname, err)
+	}
+}
+
+func TestPrepareDatabasePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dbname   string
+		expected string
+	}{
+		{"file::memory:?cache=shared", "file::memory:?cache=shared"},
+		{"file:test?mode=memory&cache=shared", "file:test?mode=memory&cache=shared"},
+		{"~/cola/cola.db", filepath.Join(home, "cola", "cola.db")},
+		{"file:~/cola/uri.db?_busy_timeout=5000", "file:" + filepath.Join(home, "cola", "uri.db") + "?_busy_timeout=5000"},
+		{"cola.db", filepath.Join(wd, "cola.db")},
+	}
+	for _, tt := range tests {
+		got, err := prepareDatabasePath(tt.dbname, 0o750)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.dbname, tt.expected, got)
+		}
+	}
+	info, err := os.Stat(filepath.Join(home, "cola"))
+	if err != nil || info.Mode().Perm() != 0o750 {
+		t.Fatalf("expected the directory to be created with 0750: %v %v", info, err)
+	}
+
+	// a file in the way of the directory
+	blocker := filepath.Join(home, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prepareDatabasePath(filepath.Join(blocker, "cola.db"), 0o750); err == nil {
+		t.Fatalf("expected an error creating the directory over a file")
+	}
+}
+
+func TestConcurrentReservations(t *testing.T) {
+	testApp := NewApp(filepath.Join(t.TempDir(), "cola.db"))
+	defer testApp.db.Close()
+	var mode string
+	if err := testApp.db.Get(&mode, "PRAGMA journal_mode"); err != nil || mode != "wal" {
+		t.Fatalf("expected the write-ahead log, got %q: %v", mode, err)
+	}
+	var timeout int
+	if err := testApp.db.Get(&timeout, "PRAGMA busy_timeout"); err != nil || timeout != 5000 {
+		t.Fatalf("expected a busy timeout of 5000ms, got %d: %v", timeout, err)
+	}
+	// on every connection, not only the one creating the schema
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		conn, err := testApp.db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fk int
+		if err := conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+			t.Fatalf("expected the foreign keys on connection %d, got %d: %v", i, fk, err)
+		}
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"busy_queue"}`)
+
+	const n = 20
+	var wg sync.WaitGroup
+	failures := make(chan string, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d"}`, i, 100000000+i))
+			if w.Code != http.StatusCreated {
+				failures <- w.Body.String()
+			}
+		}(i)
+		// the reads in between
+		go func() {
+			defer wg.Done()
+			if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", ""); w.Code != http.StatusOK {
+				failures <- w.Body.String()
+			}
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for f := range failures {
+		t.Errorf("unexpected failure: %s", f)
+	}
+	var positions []int64
+	if err := testApp.db.Select(&positions, "SELECT DISTINCT position FROM reservation WHERE queueid=1"); err != nil || len(positions) != n {
+		t.Fatalf("expected %d distinct positions, got %v: %v", n, positions, err)
+	}
+}
+
+func TestDefaultQueue(t *testing.T) {
+	defaultQueue = "demo_queue"
+	defer func() { defaultQueue = "" }()
+	dbname := filepath.Join(t.TempDir(), "cola.db")
+
+	testApp := NewApp(dbname)
+	w := doRequest(testApp, "GET", "/api/v1/queue/1", "")
+	var q Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil || w.Code != http.StatusOK || q.Name != "demo_queue" {
+		t.Fatalf("expected the default queue, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the default queue to take reservations, got %d %s", w.Code, w.Body.String())
+	}
+	testApp.db.Close()
+
+	// not again once there are queues
+	defaultQueue = "another_queue"
+	testApp = NewApp(dbname)
+	defer testApp.db.Close()
+	var names []string
+	if err := testApp.db.Select(&names, "SELECT name FROM queue"); err != nil || len(names) != 1 || names[0] != "demo_queue" {
+		t.Fatalf("expected only the first default queue, got %v: %v", names, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeferRequest is a party pushing its own reservation back, it is
+// identified by the reservation id and its phone. Spots defaults to, and
+// can't exceed, -self-defer-spots.
+type DeferRequest struct {
+	ID    int64  `json:"id" binding:"required"`
+	Phone string `json:"phone" binding:"required"`
+	Spots *int64 `json:"spots" binding:"omitempty,min=1"`
+}
+
+// deferReservation lets a party running late yield its spot, moving its
+// reservation back and renumbering the queue. A reservation can be deferred
+// up to -self-defer-max times and once per -self-defer-interval.
+func (a *App) deferReservation(c *gin.Context) {
+	if a.deferSpots == 0 {
+		abortWithError(c, http.StatusNotFound, "not_found", "self-service defers are disabled")
+		return
+	}
+	id := c.Param("id")
+	var req DeferRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+	spots := a.deferSpots
+	if req.Spots != nil {
+		spots = *req.Spots
+	}
+	if spots > a.deferSpots {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "spots can not exceed " + strconv.FormatInt(a.deferSpots, 10),
+			Code:    "invalid_spots",
+			Fields:  []string{"spots"},
+			Limit:   int(a.deferSpots),
+		})
+		return
+	}
+	q, err := a.getQueue(id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", id, req.ID)
+	if err != nil && err != sql.ErrNoRows {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// a wrong phone doesn't tell the reservation exists
+	if err == sql.ErrNoRows || !a.phoneMatches(r.Phone, req.Phone) {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if a.maxDefers > 0 && r.DeferCount >= a.maxDefers {
+		c.AbortWithStatusJSON(http.StatusConflict, apiError{
+			Message: "the reservation can not be deferred more than " + strconv.FormatInt(a.maxDefers, 10) + " times",
+			Code:    "defer_limit_reached",
+			Limit:   int(a.maxDefers),
+		})
+		return
+	}
+	now := a.now().UTC()
+	if r.DeferredAt != nil {
+		if retry := r.DeferredAt.Add(a.deferInterval).Sub(now); retry > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, "rate_limited", "the reservation was just deferred, try again later")
+			return
+		}
+	}
+	slots, err := waitingOrder(tx, id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	from := indexOf(slots, r.ID)
+	to := from + int(spots)
+	if to >= len(slots) {
+		to = len(slots) - 1
+	}
+	if to == from {
+		abortWithError(c, http.StatusConflict, "already_last", "the reservation is already at the back of the queue")
+		return
+	}
+	if err := a.placeAt(tx, slots, from, to); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := tx.Exec("UPDATE reservation SET defer_count = defer_count + 1, deferred_at=$1 WHERE id=$2", now, r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "deferred", gin.H{"spots": to - from}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventUpdated, QueueID: r.QueueID, ReservationID: r.ID})
+
+	rs := []Reservation{r}
+	if err := a.setTickets(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	position := int64(to + 1)
+	p := PhonePosition{ID: r.ID, Ticket: rs[0].Ticket, PartiesAhead: position - 1}
+	if q.PositionBandSize > 0 && !a.isStaff(c) {
+		p.PositionBand = a.positionBand(position, q.PositionBandSize)
+	} else {
+		p.Position = a.reportPosition(position)
+	}
+	c.IndentedJSON(http.StatusOK, p)
+}
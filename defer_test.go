@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeferReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 19, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for i := 1; i <= 5; i++ {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000"}`, i, i))
+	}
+	order := func() []int64 {
+		t.Helper()
+		var ids []int64
+		if err := testApp.db.Select(&ids, "SELECT id FROM reservation WHERE status='waiting' ORDER BY position"); err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	t.Run("deferring", func(t *testing.T) {
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":1,"phone":"100000000"}`)
+		var p PhonePosition
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusOK || p.Position != 4 || p.PartiesAhead != 3 {
+			t.Fatalf("expected the party pushed back 3 spots, got %d %s", w.Code, w.Body.String())
+		}
+		if ids := order(); fmt.Sprint(ids) != "[2 3 4 1 5]" {
+			t.Fatalf("expected the queue renumbered, got %v", ids)
+		}
+
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":1,"phone":"100000000","spots":1}`)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+			t.Fatalf("expected 429 deferring again right away, got %d %s", w.Code, w.Body.String())
+		}
+		now = now.Add(time.Minute)
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":1,"phone":"100000000","spots":1}`)
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusOK || p.Position != 5 {
+			t.Fatalf("expected the party pushed back 1 spot, got %d %s", w.Code, w.Body.String())
+		}
+		if ids := order(); fmt.Sprint(ids) != "[2 3 4 5 1]" {
+			t.Fatalf("expected the queue renumbered, got %v", ids)
+		}
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":5,"phone":"500000000","spots":3}`)
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusOK || p.Position != 5 {
+			t.Fatalf("expected the party pushed to the back, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("cap", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":1,"phone":"100000000"}`)
+		if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "defer_limit_reached" || e.Limit != 2 {
+			t.Fatalf("expected 409 past the defers cap, got %d %s", w.Code, w.Body.String())
+		}
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":5,"phone":"500000000"}`)
+		if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "already_last" {
+			t.Fatalf("expected 409 deferring the last party, got %d %s", w.Code, w.Body.String())
+		}
+		if ids := order(); fmt.Sprint(ids) != "[2 3 4 1 5]" {
+			t.Fatalf("expected the rejected defers not to move anyone, got %v", ids)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for body, code := range map[string]int{
+			`{"id":2,"phone":"100000000"}`:           http.StatusNotFound,
+			`{"id":9,"phone":"900000000"}`:           http.StatusNotFound,
+			`{"id":2,"phone":"200000000","spots":4}`: http.StatusBadRequest,
+			`{"id":2,"phone":"200000000","spots":0}`: http.StatusBadRequest,
+			`{"id":2}`:                               http.StatusBadRequest,
+		} {
+			if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", body); w.Code != code {
+				t.Fatalf("expected %d for %s, got %d %s", code, body, w.Code, w.Body.String())
+			}
+		}
+		testApp.deferSpots = 0
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/self/defer", `{"id":2,"phone":"200000000"}`); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 with the defers disabled, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
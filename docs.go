@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIPath is the route serving the description of the API
+const openAPIPath = "/api/v1/openapi.json"
+
+// getOpenAPI describes the routes of the API as an OpenAPI document, built
+// from the routes registered so it can't drift from them. The bodies and the
+// responses of each route are described in the README.
+func (a *App) getOpenAPI(c *gin.Context) {
+	paths := map[string]gin.H{}
+	routes := a.router.Routes()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	for _, r := range routes {
+		var segments []string
+		params := []gin.H{}
+		for _, s := range strings.Split(r.Path, "/") {
+			if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+				params = append(params, gin.H{"name": s[1:], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+				s = "{" + s[1:] + "}"
+			}
+			segments = append(segments, s)
+		}
+		path := strings.Join(segments, "/")
+		if paths[path] == nil {
+			paths[path] = gin.H{}
+		}
+		op := gin.H{
+			"operationId": strings.ToLower(r.Method) + ":" + path,
+			"responses":   gin.H{"default": gin.H{"description": "see the README"}},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if strings.HasPrefix(r.Path, "/api/v1/admin/") {
+			op["security"] = []gin.H{{"admin": []string{}}}
+		}
+		paths[path][strings.ToLower(r.Method)] = op
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "cola-loca", "version": version},
+		"paths":   paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{"admin": gin.H{"type": "http", "scheme": "bearer"}},
+		},
+	})
+}
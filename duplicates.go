@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DuplicateGroup is a set of waiting reservations sharing the same
+// normalized key
+type DuplicateGroup struct {
+	Key      string  `json:"key"`
+	Count    int     `json:"count"`
+	IDs      []int64 `json:"ids"`
+	QueueIDs []int64 `json:"queueids"`
+}
+
+// duplicateKeys normalize the fields the duplicates can be looked up by
+var duplicateKeys = map[string]func(Reservation) string{
+	"phone": func(r Reservation) string { return normalizePhone(r.Phone) },
+	"name": func(r Reservation) string {
+		return strings.Join(strings.Fields(strings.ToLower(r.Name)), " ")
+	},
+}
+
+// getDuplicates groups the waiting reservations, of every queue or only of
+// the queue given, by their normalized phone or name and returns the groups
+// with more than one member ordered by key.
+func (a *App) getDuplicates(c *gin.Context) {
+	by := c.DefaultQuery("by", "phone")
+	key, ok := duplicateKeys[by]
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, "invalid_by", "by must be phone or name")
+		return
+	}
+	reservations := []Reservation{}
+	var err error
+	if queueID := c.Query("queue"); queueID != "" {
+		err = a.db.Select(&reservations, "SELECT * FROM reservation WHERE queueid=$1 AND status='waiting' ORDER BY seq ASC", queueID)
+	} else {
+		err = a.db.Select(&reservations, "SELECT * FROM reservation WHERE status='waiting' ORDER BY seq ASC")
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	groups := map[string]*DuplicateGroup{}
+	for _, r := range reservations {
+		k := key(r)
+		if k == "" {
+			continue
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &DuplicateGroup{Key: k}
+			groups[k] = g
+		}
+		g.Count++
+		g.IDs = append(g.IDs, r.ID)
+		g.QueueIDs = append(g.QueueIDs, r.QueueID)
+	}
+	duplicates := []DuplicateGroup{}
+	for _, g := range groups {
+		if g.Count > 1 {
+			duplicates = append(duplicates, *g)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Key < duplicates[j].Key })
+	// the phone is the key, the observers don't see it either
+	if by == "phone" && a.isObserver(c) {
+		for i := range duplicates {
+			duplicates[i].Key = maskPhone(duplicates[i].Key)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, duplicates)
+}
+
+// replaceReservation replaces the details of the existing reservation of
+// the phone with the ones of the new reservation r. The reservation moves to
+// the back of the queue as a new spot, unless it keeps its position.
+func (a *App) replaceReservation(c *gin.Context, q Queue, existing, r Reservation) {
+	// counting people a larger party has to fit
+	if q.CapacityBy == "people" && r.GroupSize > existing.GroupSize {
+		if fits, err := a.fitsCapacity(a.db, q, r.GroupSize-existing.GroupSize); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		} else if !fits {
+			abortWithError(c, http.StatusForbidden, "queue_full", "queue full")
+			return
+		}
+	}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	existing.Name = r.Name
+	existing.GroupSize = r.GroupSize
+	existing.Email = r.Email
+	existing.ReturnURL = r.ReturnURL
+	existing.ScheduledAt = r.ScheduledAt
+	existing.NotifyLeadSeconds = r.NotifyLeadSeconds
+	from := existing.Position
+	if !a.replaceKeepsPosition {
+		slots, err := waitingOrder(tx, strconv.FormatInt(existing.QueueID, 10))
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if err := a.placeAt(tx, slots, indexOf(slots, existing.ID), len(slots)-1); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		existing.Position = int64(len(slots))
+		existing.CreatedAt = a.now().UTC()
+		existing.NotifiedAt = nil
+		existing.SLABreachedAt = nil
+	}
+	_, err = tx.NamedExec(`UPDATE reservation SET name=:name, groupsize=:groupsize, email=:email, return_url=:return_url,
+		scheduled_at=:scheduled_at, notify_lead_seconds=:notify_lead_seconds, position=:position, created_at=:created_at,
+		notified_at=:notified_at, sla_breached_at=:sla_breached_at WHERE id=:id`, existing)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, existing.QueueID, existing.ID, "replaced", gin.H{"from": from, "to": existing.Position}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	rs := make([]Reservation, 1)
+	err = a.db.Get(&rs[0], selectReservations+" WHERE id=$2", existing.QueueID, existing.ID)
+	if err == nil {
+		err = a.decorate(rs)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventUpdated, QueueID: existing.QueueID, ReservationID: existing.ID})
+	c.IndentedJSON(http.StatusOK, rs[0])
+}
+
+// mergeReservation adds the party of the new reservation r to the existing
+// reservation of the phone, the people joining a party already in line. The
+// reservation keeps its position and the rest of its details.
+func (a *App) mergeReservation(c *gin.Context, q Queue, existing, r Reservation) {
+	from := existing.GroupSize
+	existing.GroupSize += r.GroupSize
+	if msg := q.checkGroupSize(existing.GroupSize); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_group_size", msg)
+		return
+	}
+	// the merged party takes no more room counting parties
+	if q.CapacityBy == "people" {
+		if fits, err := a.fitsCapacity(a.db, q, r.GroupSize); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		} else if !fits {
+			abortWithError(c, http.StatusForbidden, "queue_full", "queue full")
+			return
+		}
+	}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	if _, err := tx.Exec("UPDATE reservation SET groupsize=$1 WHERE id=$2", existing.GroupSize, existing.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, existing.QueueID, existing.ID, "merged", gin.H{"from": from, "to": existing.GroupSize}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	rs := make([]Reservation, 1)
+	err = a.db.Get(&rs[0], selectReservations+" WHERE id=$2", existing.QueueID, existing.ID)
+	if err == nil {
+		err = a.decorate(rs)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventUpdated, QueueID: existing.QueueID, ReservationID: existing.ID})
+	c.IndentedJSON(http.StatusOK, rs[0])
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDuplicatesReport(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	// the same phone typed differently is the same phone in a queue
+	testApp.duplicatePhone = "allow"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"drinks_queue"}`)
+	for _, r := range []struct{ queue, body string }{
+		{"1", `{"name":"Ana Garcia","phone":"111111111"}`},
+		{"1", `{"name":"customer_2","phone":"222222222"}`},
+		{"2", `{"name":"ana  garcia","phone":"111-111-111"}`},
+		{"1", `{"name":"customer_4","phone":"111 111 111"}`},
+		{"2", `{"name":"customer_2","phone":"555555555"}`},
+	} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/"+r.queue+"/reservation", r.body); w.Code != http.StatusCreated {
+			t.Fatalf("unexpected status creating %s: %d %s", r.body, w.Code, w.Body.String())
+		}
+	}
+
+	report := func(query string) []DuplicateGroup {
+		t.Helper()
+		w := doAdminRequest(testApp, "GET", "/api/v1/admin/duplicates"+query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status for %s: %d %s", query, w.Code, w.Body.String())
+		}
+		var groups []DuplicateGroup
+		if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+			t.Fatal(err)
+		}
+		return groups
+	}
+
+	expected := []DuplicateGroup{{Key: "111111111", Count: 3, IDs: []int64{1, 3, 4}, QueueIDs: []int64{1, 2, 1}}}
+	if groups := report("?by=phone"); !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("unexpected phone duplicates: %+v", groups)
+	}
+	expected = []DuplicateGroup{
+		{Key: "ana garcia", Count: 2, IDs: []int64{1, 3}, QueueIDs: []int64{1, 2}},
+		{Key: "customer_2", Count: 2, IDs: []int64{2, 5}, QueueIDs: []int64{1, 2}},
+	}
+	if groups := report("?by=name"); !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("unexpected name duplicates: %+v", groups)
+	}
+	// within a single queue
+	expected = []DuplicateGroup{{Key: "111111111", Count: 2, IDs: []int64{1, 4}, QueueIDs: []int64{1, 1}}}
+	if groups := report("?by=phone&queue=1"); !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("unexpected phone duplicates of the queue: %+v", groups)
+	}
+	if groups := report("?by=name&queue=2"); len(groups) != 0 {
+		t.Fatalf("expected no name duplicates in the queue, got %+v", groups)
+	}
+
+	if w := doAdminRequest(testApp, "GET", "/api/v1/admin/duplicates?by=email", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown key, got %d", w.Code)
+	}
+}
+
+func TestDuplicatePhoneModes(t *testing.T) {
+	setup := func(t *testing.T, mode string) *App {
+		testApp := newTestApp(t)
+		testApp.duplicatePhone = mode
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"drinks_queue"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+		return testApp
+	}
+	names := func(t *testing.T, testApp *App) []string {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+		var rs []Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, r := range rs {
+			names = append(names, fmt.Sprintf("%s@%d", r.Name, r.Position))
+		}
+		return names
+	}
+	again := `{"name":"customer_1_again","phone":"111111111","groupsize":3}`
+
+	t.Run("reject", func(t *testing.T) {
+		testApp := setup(t, "reject")
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", again)
+		if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "phone_already_waiting" {
+			t.Fatalf("expected 409, got %d %s", w.Code, w.Body.String())
+		}
+		// nor by changing the phone, but fine in another queue
+		if w := doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/2", again); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 changing to a phone already waiting, got %d %s", w.Code, w.Body.String())
+		}
+		if w := doRequest(testApp, "POST", "/api/v1/queue/2/reservation", again); w.Code != http.StatusCreated {
+			t.Fatalf("expected the phone to join another queue, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		testApp := setup(t, "allow")
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", again); w.Code != http.StatusCreated {
+			t.Fatalf("expected a second reservation, got %d %s", w.Code, w.Body.String())
+		}
+		got := names(t, testApp)
+		if strings.Join(got, ",") != "customer_1@1,customer_2@2,customer_3@3,customer_1_again@4" {
+			t.Fatalf("unexpected reservations %v", got)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		testApp := setup(t, "replace")
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", again)
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || r.ID != 1 || r.GroupSize != 3 || r.Position != 3 || r.GroupPosition != 3 {
+			t.Fatalf("expected reservation 1 replaced at the back, got %d %s", w.Code, w.Body.String())
+		}
+		got := names(t, testApp)
+		if strings.Join(got, ",") != "customer_2@1,customer_3@2,customer_1_again@3" {
+			t.Fatalf("unexpected reservations %v", got)
+		}
+
+		testApp.replaceKeepsPosition = true
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2_again","phone":"222222222"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 replacing, got %d %s", w.Code, w.Body.String())
+		}
+		got = names(t, testApp)
+		if strings.Join(got, ",") != "customer_2_again@1,customer_3@2,customer_1_again@3" {
+			t.Fatalf("unexpected reservations keeping the position %v", got)
+		}
+
+		// counting people the larger party has to fit, 5 people waiting
+		doRequest(testApp, "PATCH", "/api/v1/queue/1", `{"capacity":6,"capacity_by":"people"}`)
+		w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3_again","phone":"333333333","groupsize":3}`)
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+			t.Fatalf("expected the larger party not fitting to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3_again","phone":"333333333","groupsize":2}`); w.Code != http.StatusOK {
+			t.Fatalf("expected the larger party fitting to replace, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		testApp := setup(t, "merge")
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2_friends","phone":"222 222 222","groupsize":3}`)
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || r.ID != 2 || r.GroupSize != 4 || r.Position != 2 || r.PersonPosition != 5 {
+			t.Fatalf("expected the party merged into reservation 2, got %d %s", w.Code, w.Body.String())
+		}
+		var rows []Reservation
+		if err := testApp.db.Select(&rows, "SELECT * FROM reservation WHERE queueid=1 AND phone='222222222'"); err != nil || len(rows) != 1 || rows[0].GroupSize != 4 {
+			t.Fatalf("expected a single row of 4 people, got %+v: %v", rows, err)
+		}
+		got := names(t, testApp)
+		if strings.Join(got, ",") != "customer_1@1,customer_2@2,customer_3@3" {
+			t.Fatalf("unexpected reservations %v", got)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// event types
+const (
+	EventCreated   = "reservation_created"
+	EventUpdated   = "reservation_updated"
+	EventDeleted   = "reservation_deleted"
+	EventSLABreach = "sla_breach"
+	EventServed    = "served"
+	// EventNotification is emitted by the default notifier
+	EventNotification = "notification"
+	// EventGoodbye is sent to the event streams closed by the server
+	EventGoodbye = "goodbye"
+)
+
+// Event describes something that happened to a queue or to one of its
+// reservations.
+type Event struct {
+	Type          string    `json:"type"`
+	QueueID       int64     `json:"queueid"`
+	ReservationID int64     `json:"reservationid,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// emit delivers the event to the registered listeners
+func (a *App) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = a.now().UTC()
+	}
+	for _, l := range a.listeners {
+		l(ev)
+	}
+}
+
+// emitReservation emits an event of the reservation identified by the path
+// parameters of a request
+func (a *App) emitReservation(eventType, queueID, reservationID string) {
+	q, _ := strconv.ParseInt(queueID, 10, 64)
+	r, _ := strconv.ParseInt(reservationID, 10, 64)
+	a.emit(Event{Type: eventType, QueueID: q, ReservationID: r})
+}
+
+// newWebhook returns a listener that posts the events to url, delivery is
+// asynchronous and best effort so slow receivers don't block the app.
+func newWebhook(url string) func(Event) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(ev Event) {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("Error encoding event %s: %v", ev.Type, err)
+			return
+		}
+		go func() {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Error posting event %s: %v", ev.Type, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushRows is the number of rows sent per chunk of the exports
+const exportFlushRows = 100
+
+// exportStream writes an export to the client as it is produced, gzipped
+// if the client accepts it.
+type exportStream struct {
+	w  io.Writer
+	gz *gzip.Writer
+	rw gin.ResponseWriter
+}
+
+func newExportStream(c *gin.Context, contentType string) *exportStream {
+	c.Header("Content-Type", contentType)
+	c.Header("Vary", "Accept-Encoding")
+	s := &exportStream{w: c.Writer, rw: c.Writer}
+	if acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Header("Content-Encoding", "gzip")
+		s.gz = gzip.NewWriter(c.Writer)
+		s.w = s.gz
+	}
+	c.Status(http.StatusOK)
+	return s
+}
+
+// acceptsGzip returns if the Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, enc := range strings.Split(header, ",") {
+		params := strings.Split(enc, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, p := range params[1:] {
+			if v := strings.TrimSpace(p); strings.HasPrefix(v, "q=") {
+				q, err := strconv.ParseFloat(v[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// flush sends what was written so far, the gzip writer has to be flushed
+// first or the data stays in its buffers.
+func (s *exportStream) flush() error {
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	s.rw.Flush()
+	return nil
+}
+
+func (s *exportStream) close() error {
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// exportServed streams the served history of the queue as NDJSON or, with
+// ?format=csv, as CSV, flushing every exportFlushRows rows so the export is
+// never buffered.
+func (a *App) exportServed(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		abortWithError(c, http.StatusBadRequest, "invalid_format", "format must be ndjson or csv")
+		return
+	}
+	observer := a.isObserver(c)
+	rows, err := a.db.Queryx("SELECT * FROM served WHERE queueid=$1 ORDER BY served_at ASC, id ASC", c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var write func(ServedReservation) error
+	var s *exportStream
+	if format == "csv" {
+		s = newExportStream(c, "text/csv; charset=utf-8")
+		cw := csv.NewWriter(s.w)
+		cw.Write([]string{"id", "reservationid", "queueid", "number", "name", "phone", "groupsize", "created_at", "served_at", "wait_seconds"})
+		write = func(r ServedReservation) error {
+			cw.Write([]string{
+				strconv.FormatInt(r.ID, 10),
+				strconv.FormatInt(r.ReservationID, 10),
+				strconv.FormatInt(r.QueueID, 10),
+				strconv.FormatInt(r.Number, 10),
+				r.Name,
+				r.Phone,
+				strconv.FormatInt(r.GroupSize, 10),
+				r.CreatedAt.Format(time.RFC3339),
+				r.ServedAt.Format(time.RFC3339),
+				strconv.FormatInt(r.WaitSeconds, 10),
+			})
+			// the csv writer buffers too
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		s = newExportStream(c, "application/x-ndjson")
+		enc := json.NewEncoder(s.w)
+		write = func(r ServedReservation) error {
+			return enc.Encode(r)
+		}
+	}
+	defer s.close()
+
+	n := 0
+	for rows.Next() {
+		var r ServedReservation
+		if err := rows.StructScan(&r); err != nil {
+			log.Printf("Error exporting the served reservations: %v", err)
+			return
+		}
+		r.WaitSeconds = int64(r.ServedAt.Sub(r.CreatedAt) / time.Second)
+		if observer {
+			r.Phone = maskPhone(r.Phone)
+		}
+		if err := write(r); err != nil {
+			log.Printf("Error exporting the served reservations: %v", err)
+			return
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			if err := s.flush(); err != nil {
+				log.Printf("Error exporting the served reservations: %v", err)
+				return
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error exporting the served reservations: %v", err)
+	}
+}
+
+// ReservationExport is a row of the reservations export, with the name and
+// the phone replaced by stable tokens when anonymized.
+type ReservationExport struct {
+	ID          int64      `json:"id"`
+	QueueID     int64      `json:"queueid"`
+	Number      int64      `json:"number"`
+	Position    int64      `json:"position"`
+	Name        string     `json:"name"`
+	Phone       string     `json:"phone"`
+	GroupSize   int64      `json:"groupsize"`
+	Priority    bool       `json:"priority"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+}
+
+// anonymize returns a token of the value keyed with -anonymize-key, the
+// same value always gets the same token so the rows can still be grouped.
+func (a *App) anonymize(prefix, value string) string {
+	mac := hmac.New(sha256.New, a.anonymizeKey)
+	mac.Write([]byte(prefix + "\n" + value))
+	return prefix + "-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// exportReservations streams the reservations of the queue, waiting or
+// not, in the order they joined as NDJSON or, with ?format=csv, as CSV. With
+// ?anonymize=true the names and the phones are replaced by tokens.
+func (a *App) exportReservations(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		abortWithError(c, http.StatusBadRequest, "invalid_format", "format must be ndjson or csv")
+		return
+	}
+	anonymized, err := strconv.ParseBool(c.DefaultQuery("anonymize", "false"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", "anonymize must be true or false")
+		return
+	}
+	observer := a.isObserver(c)
+	rows, err := a.db.Queryx(`SELECT id, queueid, number, position, name, phone, groupsize, priority, status, created_at, checked_in_at
+		FROM reservation WHERE queueid=$1 ORDER BY seq ASC`, c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var write func(ReservationExport) error
+	var s *exportStream
+	if format == "csv" {
+		s = newExportStream(c, "text/csv; charset=utf-8")
+		cw := csv.NewWriter(s.w)
+		cw.Write([]string{"id", "queueid", "number", "position", "name", "phone", "groupsize", "priority", "status", "created_at", "checked_in_at"})
+		write = func(r ReservationExport) error {
+			checkedIn := ""
+			if r.CheckedInAt != nil {
+				checkedIn = r.CheckedInAt.Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				strconv.FormatInt(r.ID, 10),
+				strconv.FormatInt(r.QueueID, 10),
+				strconv.FormatInt(r.Number, 10),
+				strconv.FormatInt(r.Position, 10),
+				r.Name,
+				r.Phone,
+				strconv.FormatInt(r.GroupSize, 10),
+				strconv.FormatBool(r.Priority),
+				r.Status,
+				r.CreatedAt.Format(time.RFC3339),
+				checkedIn,
+			})
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		s = newExportStream(c, "application/x-ndjson")
+		enc := json.NewEncoder(s.w)
+		write = func(r ReservationExport) error {
+			return enc.Encode(r)
+		}
+	}
+	defer s.close()
+
+	n := 0
+	for rows.Next() {
+		var r ReservationExport
+		if err := rows.StructScan(&r); err != nil {
+			log.Printf("Error exporting the reservations: %v", err)
+			return
+		}
+		if anonymized {
+			r.Name = a.anonymize("name", r.Name)
+			r.Phone = a.anonymize("phone", normalizePhone(r.Phone))
+		} else if observer {
+			r.Phone = maskPhone(r.Phone)
+		}
+		if err := write(r); err != nil {
+			log.Printf("Error exporting the reservations: %v", err)
+			return
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			if err := s.flush(); err != nil {
+				log.Printf("Error exporting the reservations: %v", err)
+				return
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error exporting the reservations: %v", err)
+	}
+}
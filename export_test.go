@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flushRecorder counts the flushes of the response
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestExportServedGzip(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"export_queue"}`)
+	created := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	rows := 250
+	for i := 0; i < rows; i++ {
+		testApp.db.MustExec(`INSERT INTO served (reservationid, queueid, number, name, phone, groupsize, created_at, served_at)
+			VALUES ($1, 1, $1, 'customer, "quoted"', '111111111', 2, $2, $3)`, i+1, created, created.Add(time.Duration(i)*time.Minute))
+	}
+
+	export := func(url string) (*flushRecorder, *gzip.Reader) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		testApp.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected a gzipped export, got %d %v", w.Code, w.Header())
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, gz
+	}
+
+	w, gz := export("/api/v1/queue/1/served/export")
+	if w.flushes < rows/exportFlushRows {
+		t.Fatalf("expected the export to be flushed every %d rows, got %d flushes", exportFlushRows, w.flushes)
+	}
+	scanner := bufio.NewScanner(gz)
+	n := 0
+	for scanner.Scan() {
+		var s ServedReservation
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.ReservationID != int64(n+1) || s.WaitSeconds != int64(n*60) {
+			t.Fatalf("unexpected row %d: %+v", n, s)
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil || n != rows {
+		t.Fatalf("expected %d rows, got %d: %v", rows, n, err)
+	}
+
+	_, gz = export("/api/v1/queue/1/served/export?format=csv")
+	records, err := csv.NewReader(gz).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != rows+1 || records[0][0] != "id" || records[1][4] != `customer, "quoted"` {
+		t.Fatalf("unexpected csv export with %d records: %v", len(records), records[:2])
+	}
+
+	// plain without gzip
+	w2 := doRequest(testApp, "GET", "/api/v1/queue/1/served/export?format=csv", "")
+	if w2.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected a plain export without Accept-Encoding")
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/served/export?format=xml", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", w.Code)
+	}
+}
+
+func TestExportAnonymized(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"export_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":3}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"other_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	export := func(queue, query string) []ReservationExport {
+		t.Helper()
+		w := doRequest(testApp, "GET", "/api/v1/queue/"+queue+"/export"+query, "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("unexpected export: %d %s", w.Code, w.Body.String())
+		}
+		var rs []ReservationExport
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var r ReservationExport
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+
+	plain := export("1", "")
+	if len(plain) != 2 || plain[0].Name != "customer_1" || plain[1].Phone != "222222222" {
+		t.Fatalf("expected the reservations as they are, got %+v", plain)
+	}
+	anon := export("1", "?anonymize=true")
+	if len(anon) != len(plain) {
+		t.Fatalf("expected the same rows anonymized, got %+v", anon)
+	}
+	for i, r := range anon {
+		p := plain[i]
+		if r.Name == p.Name || r.Phone == p.Phone || r.Name == "" || r.Phone == "" {
+			t.Fatalf("expected the name and phone replaced, got %+v", r)
+		}
+		// everything else is kept
+		r.Name, r.Phone = p.Name, p.Phone
+		if r.ID != p.ID || r.Position != p.Position || r.GroupSize != p.GroupSize || r.Status != p.Status || !r.CreatedAt.Equal(p.CreatedAt) {
+			t.Fatalf("expected the structure preserved, got %+v for %+v", r, p)
+		}
+	}
+	if anon[0].Status != StatusServed || anon[1].Status != StatusWaiting || anon[1].GroupSize != 3 {
+		t.Fatalf("expected the statuses and sizes kept, got %+v", anon)
+	}
+	// the same person gets the same tokens
+	if other := export("2", "?anonymize=true"); len(other) != 1 || other[0].Name != anon[0].Name || other[0].Phone != anon[0].Phone {
+		t.Fatalf("expected stable tokens, got %+v and %+v", other, anon[0])
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/export?anonymize=true&format=csv", "")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) != 3 || records[0][4] != "name" || records[1][4] != anon[0].Name || records[1][5] != anon[0].Phone {
+		t.Fatalf("expected the anonymized CSV, got %v %v", records, err)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/export?anonymize=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid anonymize, got %d", w.Code)
+	}
+}
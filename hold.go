@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// holdReservation holds the reservation for the ?timeout= duration, or the
+// default hold timeout, so calling the next party skips it. A reservation
+// already held can't be held again until released or timed out.
+func (a *App) holdReservation(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	timeout := a.holdTimeout
+	if v := c.Query("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			abortWithError(c, http.StatusBadRequest, "invalid_timeout", "timeout must be a positive duration")
+			return
+		}
+		timeout = d
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, selectReservations+" WHERE id=$2", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	now := a.now().UTC()
+	if r.HeldUntil != nil && r.HeldUntil.After(now) {
+		abortWithError(c, http.StatusConflict, "reservation_held", "reservation already held")
+		return
+	}
+	until := now.Add(timeout)
+	if _, err := tx.Exec("UPDATE reservation SET held_until=$1 WHERE id=$2", until, r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "held", gin.H{"until": until}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	r.HeldUntil = &until
+	rs := []Reservation{r}
+	if err := a.decorate(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emitReservation(EventUpdated, id, rsvp)
+	c.IndentedJSON(http.StatusOK, rs[0])
+}
+
+// releaseReservation releases a held reservation, so it can be called again
+func (a *App) releaseReservation(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := tx.Exec("UPDATE reservation SET held_until=NULL WHERE id=$1", r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "released", gin.H{"held_until": r.HeldUntil}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emitReservation(EventUpdated, id, rsvp)
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
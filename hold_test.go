@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHoldReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"hold_queue"}`)
+	for _, body := range []string{
+		`{"name":"customer_1","phone":"111111111"}`,
+		`{"name":"customer_2","phone":"222222222"}`,
+		`{"name":"customer_3","phone":"333333333"}`,
+	} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+	}
+	next := func() ServedReservation {
+		t.Helper()
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+		}
+		var s ServedReservation
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/hold", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || r.HeldUntil == nil || !r.HeldUntil.Equal(now.Add(testApp.holdTimeout)) || r.Position != 1 {
+		t.Fatalf("expected the reservation to be held keeping its position, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/hold", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 holding a held reservation, got %d", w.Code)
+	}
+	// peeking skips it too, in the order next serves
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=3", "")
+	var peeked []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &peeked); err != nil || len(peeked) != 2 || peeked[0].ID != 2 || peeked[1].ID != 3 {
+		t.Fatalf("expected the held reservation not peeked, got %d %s", w.Code, w.Body.String())
+	}
+	if s := next(); s.ReservationID != 2 {
+		t.Fatalf("expected the held reservation to be skipped, served %d", s.ReservationID)
+	}
+
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/release", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 releasing, got %d %s", w.Code, w.Body.String())
+	}
+	if s := next(); s.ReservationID != 1 {
+		t.Fatalf("expected the released reservation to be served, served %d", s.ReservationID)
+	}
+
+	// holds time out
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/3/hold?timeout=1m", "")
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/next", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected no party to call while held, got %d %s", w.Code, w.Body.String())
+	}
+	now = now.Add(time.Minute)
+	if s := next(); s.ReservationID != 3 {
+		t.Fatalf("expected the hold to time out, served %d", s.ReservationID)
+	}
+}
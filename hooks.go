@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hookSignatureHeader carries the HMAC-SHA256 of the inbound webhook body,
+// hex encoded and prefixed with "sha256=".
+const hookSignatureHeader = "X-Cola-Signature"
+
+// Hook is the inbound webhook configuration of a queue
+type Hook struct {
+	Secret string `json:"secret" binding:"required,min=16"`
+}
+
+// signHook returns the signature header value of the body for the secret
+func signHook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// receiveHook serves the next party of the queue when another system, e.g.
+// a point of sale freeing a table, sends a request signed with the secret
+// of the queue.
+func (a *App) receiveHook(c *gin.Context) {
+	queueID := c.Param("queue")
+	body, err := c.GetRawData()
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	var secret string
+	err = a.db.Get(&secret, "SELECT secret FROM queue_hook WHERE queueid=$1", queueID)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "hook_not_found", "the queue has no inbound webhook")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	signature := strings.TrimSpace(c.GetHeader(hookSignatureHeader))
+	if !hmac.Equal([]byte(signature), []byte(signHook(secret, body))) {
+		abortWithError(c, http.StatusUnauthorized, "invalid_signature", "invalid webhook signature")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, err := a.next(queueID, "")
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_empty", "no party waiting to be served")
+		return
+	}
+	if err == errInvalidConfirmation {
+		abortWithError(c, http.StatusForbidden, "invalid_confirmation_code", "the queue requires a confirmation code to serve")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// putHook sets the secret of the inbound webhook of the queue
+func (a *App) putHook(c *gin.Context) {
+	queueID := c.Param("id")
+	var h Hook
+	if !a.bindJSON(c, &h) {
+		return
+	}
+	if _, err := a.getQueue(queueID); err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	_, err = tx.Exec(`INSERT INTO queue_hook (queueid, secret) VALUES ($1, $2)
+		ON CONFLICT (queueid) DO UPDATE SET secret=excluded.secret`, queueID, h.Secret)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// the secret stays out of the audit
+	id, _ := strconv.ParseInt(queueID, 10, 64)
+	if err := a.audit(tx, id, 0, "hook_configured", nil); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// deleteHook disables the inbound webhook of the queue
+func (a *App) deleteHook(c *gin.Context) {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	res, err := tx.Exec("DELETE FROM queue_hook WHERE queueid=$1", c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		a.deleteNotFound(c, "hook_not_found", "the queue has no inbound webhook")
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err := a.audit(tx, id, 0, "hook_deleted", nil); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
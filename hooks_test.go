@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInboundHook(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"tables_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+
+	hook := func(signature string) *httptest.ResponseRecorder {
+		body := `{"event":"table_freed","table":7}`
+		req := httptest.NewRequest("POST", "/api/v1/hooks/1", strings.NewReader(body))
+		req.Header.Set(hookSignatureHeader, signature)
+		w := httptest.NewRecorder()
+		testApp.router.ServeHTTP(w, req)
+		return w
+	}
+	body := []byte(`{"event":"table_freed","table":7}`)
+
+	if w := hook(signHook("0123456789abcdef", body)); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a hook configured, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doAdminRequest(testApp, "PUT", "/api/v1/admin/queue/1/hook", `{"secret":"short"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a short secret to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doAdminRequest(testApp, "PUT", "/api/v1/admin/queue/1/hook", `{"secret":"0123456789abcdef"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the hook to be configured, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, signature := range []string{"", "sha256=00", signHook("another secret value", body)} {
+		w := hook(signature)
+		if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "invalid_signature" {
+			t.Fatalf("expected signature %q to be rejected, got %d %s", signature, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/count", ""); !strings.Contains(w.Body.String(), `"waiting": 2`) {
+		t.Fatalf("expected nobody served by the rejected hooks, got %s", w.Body.String())
+	}
+
+	w := hook(signHook("0123456789abcdef", body))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name": "customer_1"`) {
+		t.Fatalf("expected the signed hook to serve customer_1, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	if strings.Contains(w.Body.String(), "customer_1") || !strings.Contains(w.Body.String(), "customer_2") {
+		t.Fatalf("expected only customer_2 waiting, got %s", w.Body.String())
+	}
+
+	if w := doAdminRequest(testApp, "DELETE", "/api/v1/admin/queue/1/hook", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the hook to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if w := hook(signHook("0123456789abcdef", body)); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 once the hook is deleted, got %d %s", w.Code, w.Body.String())
+	}
+}
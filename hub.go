@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errTooManySubscribers = errors.New("too many subscribers")
+
+// subscriber is an open event stream of a queue
+type subscriber struct {
+	ID          int64     `json:"id"`
+	QueueID     int64     `json:"queueid"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	events      chan Event
+	// revoked is closed to make the stream end
+	revoked   chan struct{}
+	revokeOne sync.Once
+}
+
+// hub fans out the events of every queue to the subscribers of the queue
+type hub struct {
+	mu sync.Mutex
+	// maxPerQueue caps the subscribers of a queue, 0 is unlimited
+	maxPerQueue int
+	lastID      int64
+	subscribers map[int64]*subscriber
+	perQueue    map[int64]int
+	// closing tells the streams to say goodbye and end
+	closing   chan struct{}
+	closeOnce sync.Once
+	// streams tracks the subscribers until their streams end
+	streams sync.WaitGroup
+}
+
+func newHub(maxPerQueue int) *hub {
+	return &hub{
+		maxPerQueue: maxPerQueue,
+		subscribers: map[int64]*subscriber{},
+		perQueue:    map[int64]int{},
+		closing:     make(chan struct{}),
+	}
+}
+
+// close ends the open streams and waits up to timeout for them to finish,
+// it returns false if some didn't in time.
+func (h *hub) close(timeout time.Duration) bool {
+	h.closeOnce.Do(func() { close(h.closing) })
+	done := make(chan struct{})
+	go func() {
+		h.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (h *hub) subscribe(queueID int64, remoteAddr string, now time.Time) (*subscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxPerQueue > 0 && h.perQueue[queueID] >= h.maxPerQueue {
+		return nil, errTooManySubscribers
+	}
+	h.lastID++
+	s := &subscriber{
+		ID:          h.lastID,
+		QueueID:     queueID,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: now,
+		events:      make(chan Event, 16),
+		revoked:     make(chan struct{}),
+	}
+	h.subscribers[s.ID] = s
+	h.perQueue[queueID]++
+	h.streams.Add(1)
+	return s, nil
+}
+
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s.ID]; !ok {
+		return
+	}
+	delete(h.subscribers, s.ID)
+	h.streams.Done()
+	h.perQueue[s.QueueID]--
+	if h.perQueue[s.QueueID] == 0 {
+		delete(h.perQueue, s.QueueID)
+	}
+}
+
+// list returns the open streams sorted by queue and id
+func (h *hub) list() []*subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]*subscriber, 0, len(h.subscribers))
+	for _, s := range h.subscribers {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].QueueID != list[j].QueueID {
+			return list[i].QueueID < list[j].QueueID
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// revoke tells the stream of the subscriber to end, it returns false if
+// there is no such subscriber. The subscriber is removed once its stream
+// ends.
+func (h *hub) revoke(id int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.subscribers[id]
+	if !ok {
+		return false
+	}
+	s.revokeOne.Do(func() { close(s.revoked) })
+	return true
+}
+
+// publish sends the event to the subscribers of its queue, the events are
+// dropped for the subscribers too slow to keep up.
+func (h *hub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.subscribers {
+		if s.QueueID != ev.QueueID {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+		}
+	}
+}
+
+// counts returns the number of subscribers per queue
+func (h *hub) counts() map[int64]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make(map[int64]int, len(h.perQueue))
+	for q, n := range h.perQueue {
+		counts[q] = n
+	}
+	return counts
+}
+
+// ticker returns a channel ticking every interval and the function to stop
+// it, a non positive interval never ticks.
+func ticker(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(interval)
+	return t.C, t.Stop
+}
+
+// idleTimer fires once a stream went without events for its timeout, a non
+// positive timeout never fires.
+type idleTimer struct {
+	C       <-chan time.Time
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	if timeout <= 0 {
+		return &idleTimer{}
+	}
+	t := time.NewTimer(timeout)
+	return &idleTimer{C: t.C, timer: t, timeout: timeout}
+}
+
+// reset restarts the timeout, draining a fire not received yet
+func (t *idleTimer) reset() {
+	if t.timer == nil {
+		return
+	}
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.timer.Reset(t.timeout)
+}
+
+func (t *idleTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// streamEvents streams the events of the queue as server sent events
+func (a *App) streamEvents(c *gin.Context) {
+	id := c.Param("id")
+	queueID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_queue_id", "invalid queue id")
+		return
+	}
+	var exists bool
+	if err := a.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM queue WHERE id=$1)", queueID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !exists {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	s, err := a.hub.subscribe(queueID, c.ClientIP(), a.now().UTC())
+	if err == errTooManySubscribers {
+		c.Header("Retry-After", strconv.Itoa(subscribeRetryAfter))
+		abortWithError(c, http.StatusServiceUnavailable, "too_many_subscribers", "too many subscribers for this queue, try again later")
+		return
+	}
+	defer a.hub.unsubscribe(s)
+	heartbeat, stopHeartbeat := ticker(a.heartbeatInterval)
+	defer stopHeartbeat()
+	idle := newIdleTimer(a.maxIdle)
+	defer idle.stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	// let the client know the subscription is ready
+	c.Writer.WriteString(": connected\n\n")
+	c.Writer.Flush()
+	for {
+		select {
+		case ev := <-s.events:
+			c.SSEvent(ev.Type, ev)
+			c.Writer.Flush()
+			idle.reset()
+		case <-heartbeat:
+			c.Writer.WriteString(": keepalive\n\n")
+			c.Writer.Flush()
+		case <-idle.C:
+			c.SSEvent(EventGoodbye, gin.H{"reason": "idle"})
+			c.Writer.Flush()
+			return
+		case <-a.hub.closing:
+			c.SSEvent(EventGoodbye, gin.H{"reason": "shutdown"})
+			c.Writer.Flush()
+			return
+		case <-s.revoked:
+			c.SSEvent(EventGoodbye, gin.H{"reason": "revoked"})
+			c.Writer.Flush()
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// getSubscribers lists the open event streams of every queue
+func (a *App) getSubscribers(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, a.hub.list())
+}
+
+// revokeSubscriber closes the event stream of the subscriber
+func (a *App) revokeSubscriber(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_subscriber_id", "invalid subscriber id")
+		return
+	}
+	if !a.hub.revoke(id) {
+		a.deleteNotFound(c, "subscriber_not_found", "subscriber not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// subscribeRetryAfter is the Retry-After, in seconds, sent to the clients
+// rejected because the queue has too many subscribers
+const subscribeRetryAfter = 5
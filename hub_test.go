@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscribe opens an event stream of the queue, it returns once the server
+// confirmed the subscription.
+func subscribe(t *testing.T, url string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	reader := bufio.NewReader(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return resp, reader
+	}
+	for _, expected := range []string{": connected\n", "\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != expected {
+			t.Fatalf("unexpected stream start %q: %v", line, err)
+		}
+	}
+	return resp, reader
+}
+
+func TestMaxSubscribersPerQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.hub.maxPerQueue = 2
+	testApp.queueLabels = queueLabels{all: true}
+	server := httptest.NewServer(testApp.router)
+	// registered first so it runs after the streams are closed
+	t.Cleanup(server.Close)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"popular_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"quiet_queue12"}`)
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := subscribe(t, server.URL+"/api/v1/queue/1/events"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected subscriber %d to be accepted, got %d", i, resp.StatusCode)
+		}
+	}
+	resp, _ := subscribe(t, server.URL+"/api/v1/queue/1/events")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After over the cap, got %d %v", resp.StatusCode, resp.Header)
+	}
+	// the cap is per queue
+	resp, reader := subscribe(t, server.URL+"/api/v1/queue/2/events")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the other queue to accept subscribers")
+	}
+
+	w := doRequest(testApp, "GET", "/metrics", "")
+	if !strings.Contains(w.Body.String(), `cola_subscribers{queue="1"} 2`) || !strings.Contains(w.Body.String(), `cola_subscribers{queue="2"} 1`) {
+		t.Fatalf("unexpected subscribers metric: %s", w.Body.String())
+	}
+
+	// the stream delivers the events of the queue
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "event:"+EventCreated+"\n" {
+		t.Fatalf("unexpected event %q: %v", line, err)
+	}
+}
+
+func TestRevokeSubscriber(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	server := httptest.NewServer(testApp.router)
+	t.Cleanup(server.Close)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"stream_queue"}`)
+	_, reader := subscribe(t, server.URL+"/api/v1/queue/1/events")
+
+	w := doAdminRequest(testApp, "GET", "/api/v1/admin/subscribers", "")
+	var subscribers []subscriber
+	if err := json.Unmarshal(w.Body.Bytes(), &subscribers); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(subscribers) != 1 || subscribers[0].QueueID != 1 ||
+		subscribers[0].RemoteAddr == "" || subscribers[0].ConnectedAt.IsZero() {
+		t.Fatalf("unexpected subscribers: %d %s", w.Code, w.Body.String())
+	}
+
+	id := strconv.FormatInt(subscribers[0].ID, 10)
+	if w := doAdminRequest(testApp, "DELETE", "/api/v1/admin/subscribers/"+id, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status revoking the subscriber: %d %s", w.Code, w.Body.String())
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "event:"+EventGoodbye+"\n" {
+		t.Fatalf("expected a goodbye, got %q: %v", line, err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("expected the stream to end: %v", err)
+	}
+	if n := len(testApp.hub.list()); n != 0 {
+		t.Fatalf("expected the subscriber to be gone, got %d", n)
+	}
+	if w := doAdminRequest(testApp, "DELETE", "/api/v1/admin/subscribers/"+id, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 revoking it again, got %d", w.Code)
+	}
+}
+
+func TestHeartbeatAndMaxIdle(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.heartbeatInterval = 50 * time.Millisecond
+	server := httptest.NewServer(testApp.router)
+	t.Cleanup(server.Close)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"stream_queue"}`)
+
+	t.Run("heartbeat", func(t *testing.T) {
+		_, reader := subscribe(t, server.URL+"/api/v1/queue/1/events")
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			for _, expected := range []string{": keepalive\n", "\n"} {
+				line, err := reader.ReadString('\n')
+				if err != nil || line != expected {
+					t.Fatalf("expected a keepalive, got %q: %v", line, err)
+				}
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+			t.Fatalf("expected 3 keepalives every 50ms, got them in %v", elapsed)
+		}
+		// the events go through between the keepalives
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "event:"+EventCreated+"\n" {
+				break
+			}
+			if line != ": keepalive\n" && line != "\n" {
+				t.Fatalf("unexpected line %q", line)
+			}
+		}
+	})
+
+	t.Run("max idle", func(t *testing.T) {
+		testApp.maxIdle = 200 * time.Millisecond
+		_, reader := subscribe(t, server.URL+"/api/v1/queue/1/events")
+		var goodbye bool
+		for {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "event:"+EventGoodbye+"\n" {
+				goodbye = true
+			}
+			if strings.HasPrefix(line, "data:") && goodbye && !strings.Contains(line, `"idle"`) {
+				t.Fatalf("unexpected goodbye %q", line)
+			}
+		}
+		if !goodbye {
+			t.Fatalf("expected a goodbye closing the idle stream")
+		}
+	})
+}
+
+func TestMetricsQueueLabels(t *testing.T) {
+	testApp := newTestApp(t)
+	server := httptest.NewServer(testApp.router)
+	t.Cleanup(server.Close)
+	for _, name := range []string{"first_queue", "second_queue", "third_queue"} {
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"`+name+`"}`)
+	}
+	for _, queue := range []string{"1", "2", "3", "3"} {
+		subscribe(t, server.URL+"/api/v1/queue/"+queue+"/events")
+	}
+
+	// aggregated by default
+	body := doRequest(testApp, "GET", "/metrics", "").Body.String()
+	if strings.Contains(body, "queue=") || !strings.Contains(body, "cola_subscribers 4\n") {
+		t.Fatalf("expected only the aggregated subscribers, got %s", body)
+	}
+
+	labels, err := parseQueueLabels("1, 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.queueLabels = labels
+	body = doRequest(testApp, "GET", "/metrics", "").Body.String()
+	for _, expected := range []string{`cola_subscribers{queue="1"} 1`, `cola_subscribers{queue="3"} 2`, `cola_subscribers{queue="other"} 1`} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %s, got %s", expected, body)
+		}
+	}
+	if strings.Contains(body, `queue="2"`) {
+		t.Fatalf("expected the queue not allowed aggregated, got %s", body)
+	}
+
+	if _, err := parseQueueLabels("1,first"); err == nil {
+		t.Fatalf("expected an error for an invalid queue id")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// translator renders the human messages from bundles of templates keyed
+// by message code, one bundle per language.
+type translator struct {
+	fallback string
+	bundles  map[string]map[string]*template.Template
+}
+
+// newTranslator loads the builtin bundles and then the ones in dir, if
+// any, which override them; fallback is used for the unknown languages.
+func newTranslator(dir, fallback string) (*translator, error) {
+	t := &translator{
+		fallback: fallback,
+		bundles:  map[string]map[string]*template.Template{},
+	}
+	sub, err := fs.Sub(localeFiles, "locales")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.load(sub); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := t.load(os.DirFS(dir)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// load reads the <lang>.json bundles of fsys
+func (t *translator) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return err
+		}
+		lang := strings.ToLower(strings.TrimSuffix(path.Base(file), ".json"))
+		if t.bundles[lang] == nil {
+			t.bundles[lang] = map[string]*template.Template{}
+		}
+		for code, msg := range messages {
+			tmpl, err := template.New(code).Parse(msg)
+			if err != nil {
+				return err
+			}
+			t.bundles[lang][code] = tmpl
+		}
+	}
+	return nil
+}
+
+// has reports if there is a bundle for the language
+func (t *translator) has(lang string) bool {
+	_, ok := t.bundles[lang]
+	return ok
+}
+
+// lang returns the preferred language of the Accept-Language header with
+// a bundle, or the fallback.
+func (t *translator) lang(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		c := candidate{lang: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, param := range fields[1:] {
+			if v := strings.TrimPrefix(strings.TrimSpace(param), "q="); v != param {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					c.q = q
+				}
+			}
+		}
+		if c.lang != "" && c.q > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if lang, ok := t.resolve(c.lang); ok {
+			return lang
+		}
+	}
+	return t.fallback
+}
+
+// resolve returns the language with a bundle for the locale, es-ES falls
+// back to es, and false if there is none.
+func (t *translator) resolve(locale string) (string, bool) {
+	locale = strings.ToLower(locale)
+	for _, lang := range []string{locale, strings.Split(locale, "-")[0]} {
+		if t.has(lang) {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// message renders the message code in lang with data, falling back to the
+// fallback language and then to the code itself.
+func (t *translator) message(lang, code string, data interface{}) string {
+	for _, l := range []string{lang, t.fallback} {
+		tmpl, ok := t.bundles[l][code]
+		if !ok {
+			continue
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return code
+		}
+		return b.String()
+	}
+	return code
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalizedStatus(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"i18n_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+
+	tests := []struct {
+		acceptLanguage string
+		message        string
+	}{
+		{"es", "Eres el número 2 de la cola, hay 1 grupos delante de ti."},
+		{"es-ES,es;q=0.9,en;q=0.8", "Eres el número 2 de la cola, hay 1 grupos delante de ti."},
+		{"fr-FR,en;q=0.5", "You are number 2 in line, 1 parties ahead of you."},
+		{"de", "You are number 2 in line, 1 parties ahead of you."},
+		{"", "You are number 2 in line, 1 parties ahead of you."},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/queue/1/reservation/2/status", nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		w := httptest.NewRecorder()
+		testApp.router.ServeHTTP(w, req)
+		var s ReservationStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || s.Message != tt.message {
+			t.Errorf("Accept-Language %q: expected %q, got %d %q", tt.acceptLanguage, tt.message, w.Code, s.Message)
+		}
+	}
+
+	// error messages are localized too, the code stays the same
+	req := httptest.NewRequest("GET", "/api/v1/queue/1/reservation/9/status", nil)
+	req.Header.Set("Accept-Language", "es")
+	w := httptest.NewRecorder()
+	testApp.router.ServeHTTP(w, req)
+	e, ok := decodeError(w)
+	if w.Code != http.StatusNotFound || !ok || e.Code != "reservation_not_found" || e.Message != "reserva no encontrada" {
+		t.Fatalf("unexpected localized error: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTranslatorBundlesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ca.json"), []byte(`{"status.next": "Ets el següent!"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := newTranslator(dir, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang := tr.lang("ca-ES"); lang != "ca" {
+		t.Fatalf("expected ca, got %s", lang)
+	}
+	if msg := tr.message("ca", "status.next", nil); msg != "Ets el següent!" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	// missing codes fall back to the default language
+	if msg := tr.message("ca", "reservation_not_found", nil); msg != "reservation not found" {
+		t.Fatalf("unexpected fallback message %q", msg)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JoinLink is a shareable URL to join a queue, valid until it expires
+type JoinLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signJoin returns the signature of the join link of a queue, so the queue
+// id and the expiry can not be tampered with.
+func (a *App) signJoin(queueID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(a.joinLinkSecret))
+	mac.Write([]byte(queueID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyJoin checks the signature and the expiry of a join link, it returns
+// the error code and message to reply with or empty if it is valid.
+func (a *App) verifyJoin(queueID, expires, signature string) (string, string) {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "invalid_signature", "invalid join link"
+	}
+	if !hmac.Equal([]byte(signature), []byte(a.signJoin(queueID, exp))) {
+		return "invalid_signature", "invalid join link"
+	}
+	if a.now().Unix() > exp {
+		return "link_expired", "the join link has expired"
+	}
+	return "", ""
+}
+
+// requestScheme returns the scheme the client used, the one forwarded by a
+// proxy if any.
+func requestScheme(c *gin.Context) string {
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func (a *App) getJoinLink(c *gin.Context) {
+	if a.joinLinkSecret == "" {
+		abortWithError(c, http.StatusNotFound, "not_found", "join links are disabled")
+		return
+	}
+	queueID := c.Param("id")
+	var exists bool
+	if err := a.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM queue WHERE id=$1)", queueID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !exists {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	expiresAt := a.now().Add(a.joinLinkTTL).UTC().Truncate(time.Second)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Set("signature", a.signJoin(queueID, expiresAt.Unix()))
+	u := url.URL{
+		Scheme:   requestScheme(c),
+		Host:     c.Request.Host,
+		Path:     "/api/v1/queue/" + queueID + "/join",
+		RawQuery: q.Encode(),
+	}
+	c.IndentedJSON(http.StatusOK, JoinLink{URL: u.String(), ExpiresAt: expiresAt})
+}
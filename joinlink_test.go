@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinLink(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"join_link_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"other_link_queue"}`)
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/join-link", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected join links to be disabled, got %d", w.Code)
+	}
+
+	testApp.joinLinkSecret = "s3cr3t"
+	testApp.joinLinkTTL = time.Hour
+	w = doRequest(testApp, "GET", "/api/v1/queue/3/join-link", "")
+	if e, _ := decodeError(w); w.Code != http.StatusNotFound || e.Code != "queue_not_found" {
+		t.Fatalf("expected 404 for an unknown queue, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/join-link", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var link JoinLink
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	if !link.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the link to expire in an hour, got %v", link.ExpiresAt)
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/api/v1/queue/1/join" {
+		t.Fatalf("unexpected join link %s", link.URL)
+	}
+	customer := "&name=link_customer&phone=123456789"
+
+	// tampering with the queue id or the expiry
+	tampered := "/api/v1/queue/2/join?" + u.RawQuery + customer
+	w = doRequest(testApp, "GET", tampered, "")
+	if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "invalid_signature" {
+		t.Fatalf("expected a tampered queue to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	q := u.Query()
+	q.Set("expires", "9999999999")
+	tampered = u.Path + "?" + q.Encode() + customer
+	w = doRequest(testApp, "GET", tampered, "")
+	if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "invalid_signature" {
+		t.Fatalf("expected a tampered expiry to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "GET", u.RequestURI()+customer, "")
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"queueid": 1`) {
+		t.Fatalf("expected 201 joining with the link, got %d %s", w.Code, w.Body.String())
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	w = doRequest(testApp, "GET", u.RequestURI()+"&name=late_customer&phone=987654321", "")
+	if e, _ := decodeError(w); w.Code != http.StatusUnauthorized || e.Code != "link_expired" {
+		t.Fatalf("expected an expired link to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+}
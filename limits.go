@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lengthError is a field longer than its configured maximum
+type lengthError struct {
+	Field string
+	Limit int
+}
+
+func (e *lengthError) Error() string {
+	return fmt.Sprintf("%s must be at most %d characters", e.Field, e.Limit)
+}
+
+// checkLengths returns the first of the name or the phone longer than its
+// maximum, a non positive maximum is unlimited.
+func (a *App) checkLengths(name, phone string) *lengthError {
+	if a.maxNameLength > 0 && utf8.RuneCountInString(name) > a.maxNameLength {
+		return &lengthError{Field: "name", Limit: a.maxNameLength}
+	}
+	if a.maxPhoneLength > 0 && utf8.RuneCountInString(phone) > a.maxPhoneLength {
+		return &lengthError{Field: "phone", Limit: a.maxPhoneLength}
+	}
+	return nil
+}
+
+// abortWithLengthError replies 400 with the field and its limit
+func abortWithLengthError(c *gin.Context, e *lengthError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+		Message: e.Error(),
+		Code:    e.Field + "_too_long",
+		Fields:  []string{e.Field},
+		Limit:   e.Limit,
+	})
+}
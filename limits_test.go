@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxLengths(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"limits_queue"}`)
+	longName := strings.Repeat("ñ", 101)
+	longPhone := strings.Repeat("1", 21)
+
+	for _, tc := range []struct {
+		method, url, body, code string
+		limit                   int
+	}{
+		{"POST", "/api/v1/queue/1/reservation", `{"name":"` + longName + `","phone":"111111111"}`, "name_too_long", 100},
+		{"POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"` + longPhone + `"}`, "phone_too_long", 20},
+		{"PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"` + longPhone + `"}`, "phone_too_long", 20},
+	} {
+		w := doRequest(testApp, tc.method, tc.url, tc.body)
+		e, _ := decodeError(w)
+		if w.Code != http.StatusBadRequest || e.Code != tc.code || e.Limit != tc.limit || len(e.Fields) != 1 {
+			t.Fatalf("expected 400 %s with limit %d, got %d %s", tc.code, tc.limit, w.Code, w.Body.String())
+		}
+	}
+	// exactly at the limit, counting characters
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"`+longName[2:]+`","phone":"111111111"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected a name at the limit to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/reservation/validate", `[{"name":"customer_2","phone":"`+longPhone+`"}]`)
+	var report ValidationReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.Invalid != 1 || len(report.Items[0].Errors) != 1 || report.Items[0].Errors[0].Code != "phone_too_long" || report.Items[0].Errors[0].Limit != 20 {
+		t.Fatalf("expected the batch validation to report the phone length, got %s", w.Body.String())
+	}
+
+	// the digits of a valid number spaced out past the limit
+	testApp.maxPhoneLength = 0
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"+34 (600) 111 - 222 - 333"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected no limit when disabled, got %d %s", w.Code, w.Body.String())
+	}
+}
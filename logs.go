@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LogEntry is the structured log of a request
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	ClientIP  string    `json:"client_ip"`
+}
+
+// LogMessage is a message of the log package in the JSON logs
+type LogMessage struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"msg"`
+}
+
+// jsonLog writes one JSON object per line, it is safe for concurrent use
+type jsonLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONLog(w io.Writer) *jsonLog {
+	return &jsonLog{enc: json.NewEncoder(w)}
+}
+
+func (l *jsonLog) write(v interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(v)
+}
+
+// Write logs a message of the log package, which writes one per call
+func (l *jsonLog) Write(p []byte) (int, error) {
+	l.write(LogMessage{Time: time.Now().UTC(), Message: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+// logBuffer keeps the last logs in a ring buffer and tails them to the
+// subscribers, the entries are dropped for the subscribers too slow to keep
+// up.
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	// next is the slot of the next entry once the buffer is full
+	next        int
+	subscribers map[chan LogEntry]struct{}
+}
+
+func newLogBuffer(size int) *logBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &logBuffer{
+		entries:     make([]LogEntry, 0, size),
+		subscribers: map[chan LogEntry]struct{}{},
+	}
+}
+
+func (b *logBuffer) add(e LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) < cap(b.entries) {
+		b.entries = append(b.entries, e)
+	} else {
+		b.entries[b.next] = e
+		b.next = (b.next + 1) % len(b.entries)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the buffered entries, oldest first, and the channel the
+// new ones are sent to from then on.
+func (b *logBuffer) subscribe() ([]LogEntry, chan LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	backlog := make([]LogEntry, 0, len(b.entries))
+	backlog = append(backlog, b.entries[b.next:]...)
+	backlog = append(backlog, b.entries[:b.next]...)
+	ch := make(chan LogEntry, 64)
+	b.subscribers[ch] = struct{}{}
+	return backlog, ch
+}
+
+func (b *logBuffer) unsubscribe(ch chan LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// logRequests writes the structured log of every request to the buffer and,
+// with -log-format=json, to the logs
+func (a *App) logRequests(c *gin.Context) {
+	start := time.Now()
+	path := c.Request.URL.Path
+	c.Next()
+	e := LogEntry{
+		Time:      a.now().UTC(),
+		Method:    c.Request.Method,
+		Path:      path,
+		Status:    c.Writer.Status(),
+		LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+		ClientIP:  c.ClientIP(),
+	}
+	a.logs.add(e)
+	if a.jsonLog != nil {
+		a.jsonLog.write(e)
+	}
+}
+
+// streamLogs tails the buffered logs as server sent events
+func (a *App) streamLogs(c *gin.Context) {
+	backlog, ch := a.logs.subscribe()
+	defer a.logs.unsubscribe(ch)
+	heartbeat, stopHeartbeat := ticker(a.heartbeatInterval)
+	defer stopHeartbeat()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(": connected\n\n")
+	for _, e := range backlog {
+		c.SSEvent("log", e)
+	}
+	c.Writer.Flush()
+	for {
+		select {
+		case e := <-ch:
+			c.SSEvent("log", e)
+			c.Writer.Flush()
+		case <-heartbeat:
+			c.Writer.WriteString(": keepalive\n\n")
+			c.Writer.Flush()
+		case <-a.hub.closing:
+			c.SSEvent(EventGoodbye, gin.H{"reason": "shutdown"})
+			c.Writer.Flush()
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogBufferRing(t *testing.T) {
+	b := newLogBuffer(3)
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		b.add(LogEntry{Path: path})
+	}
+	backlog, _ := b.subscribe()
+	paths := []string{}
+	for _, e := range backlog {
+		paths = append(paths, e.Path)
+	}
+	if strings.Join(paths, ",") != "/c,/d,/e" {
+		t.Fatalf("expected the last 3 entries oldest first, got %v", paths)
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	server := httptest.NewServer(testApp.router)
+	t.Cleanup(server.Close)
+
+	resp, _ := subscribe(t, server.URL+"/api/v1/admin/logs")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the logs to require the admin token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/admin/logs", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status opening the logs stream: %d", resp.StatusCode)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	if w, err := http.Get(server.URL + "/api/v1/queue/42"); err != nil {
+		t.Fatal(err)
+	} else {
+		w.Body.Close()
+	}
+	// the rejected subscription comes from the backlog, the 404 is tailed
+	var entries []LogEntry
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("the stream ended before the request log: %v", err)
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var e LogEntry
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e); err != nil {
+			t.Fatalf("unexpected log line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+		if e.Path == "/api/v1/queue/42" {
+			break
+		}
+	}
+	if len(entries) != 2 || entries[0].Path != "/api/v1/admin/logs" || entries[0].Status != http.StatusUnauthorized ||
+		entries[1].Method != "GET" || entries[1].Status != http.StatusNotFound {
+		t.Fatalf("unexpected streamed logs: %+v", entries)
+	}
+}
+
+func TestJSONLogs(t *testing.T) {
+	testApp := newTestApp(t)
+	var out bytes.Buffer
+	testApp.jsonLog = newJSONLog(&out)
+	doRequest(testApp, "GET", "/api/v1/queue", "")
+	log.New(testApp.jsonLog, "", 0).Printf("Exiting: %s", "received signal")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two JSON lines, got %q", out.String())
+	}
+	var e LogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Method != "GET" || e.Path != "/api/v1/queue" || e.Status != http.StatusOK || e.ClientIP == "" || e.Time.IsZero() {
+		t.Fatalf("unexpected request log %s", lines[0])
+	}
+	var m LogMessage
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Message != "Exiting: received signal" || m.Time.IsZero() {
+		t.Fatalf("unexpected message log %s", lines[1])
+	}
+}
@@ -2,26 +2,157 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
 	"flag"
+	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/reflectx"
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/sys/unix"
 )
 
-var database string
+var (
+	database         string
+	webhookURL       string
+	auditSinkSpec    string
+	slaInterval      time.Duration
+	getJoinToken     string
+	ticketWidth      int
+	strictBinding    bool
+	deleteIdempotent bool
+	trailingSlash    bool
+	caseFoldPaths    bool
+	avgWait          time.Duration
+	localesDir       string
+	defaultLocale    string
+	maxSubscribers   int
+	heartbeat        time.Duration
+	maxIdle          time.Duration
+	databaseMode     uint
+	databaseKey      string
+	busyTimeout      time.Duration
+	maxOpenConns     int
+	notifyInterval   time.Duration
+	requestTimeout   time.Duration
+	sampleInterval   time.Duration
+	prometheus       bool
+	statsdAddr       string
+	statsdPrefix     string
+	statsdInterval   time.Duration
+	joinLinkSecret   string
+	joinLinkTTL      time.Duration
+	checkinSecret    string
+	checkinTTL       time.Duration
+	singleActive     bool
+	queueCacheSize   int
+	logBufferSize    int
+	logFormat        string
+	metricsLabels    string
+	holdTimeout      time.Duration
+	maxNameLength    int
+	maxPhoneLength   int
+	maxAttachments   int
+	maxAttachmentLen int
+	drainTimeout     time.Duration
+	killTimeout      time.Duration
+	adminToken       string
+	observerToken    string
+	returnURLHosts   string
+	anonymousName    string
+	positionBase     int
+	duplicatePhone   string
+	phoneCountryCode string
+	duplicateMove    string
+	vipBypass        string
+	priorityAging    float64
+	tableFit         string
+	anonymizeKey     string
+	defaultQueue     string
+	deferSpots       int
+	maxDefers        int
+	deferInterval    time.Duration
+	minFreeDiskMB    uint64
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
 func init() {
 	flag.StringVar(&database, "database", "./cola.db", "Specify the database filename. Default ./cola.db")
+	flag.Uint64Var(&minFreeDiskMB, "min-free-disk-mb", 100, "Specify the free megabytes required in the filesystem of the database for /readyz to succeed, 0 disables the check. Default 100")
+	flag.StringVar(&databaseKey, "db-key", "", "Specify the key the database is encrypted at rest with, it requires a build with the sqlcipher tag. Default none, not encrypted")
+	flag.DurationVar(&busyTimeout, "db-busy-timeout", 5*time.Second, "Specify how long a database connection waits for a lock held by another one before failing. Default 5s")
+	flag.IntVar(&maxOpenConns, "db-max-open-conns", 4, "Specify the maximum number of open database connections, the reads run in parallel to a write, 0 is unlimited. Default 4")
+	flag.UintVar(&databaseMode, "database-dir-mode", 0o755, "Specify the permissions of the database directory if it has to be created. Default 0755")
+	flag.StringVar(&defaultQueue, "default-queue", "", "Specify the name of a queue created at startup when there are no queues, for the demos. Default none")
+	flag.StringVar(&auditSinkSpec, "audit-sink", "", "Specify where the audit entries are also written as JSON lines: stdout, file:<path> appended to or an http(s) URL they are posted to. Default none, only the database")
+	flag.StringVar(&webhookURL, "webhook-url", "", "Specify an URL where the events are posted as JSON. Default disabled")
+	flag.StringVar(&getJoinToken, "get-join-token", "", "Enable creating reservations with GET requests authenticated by this token. Default disabled")
+	flag.IntVar(&ticketWidth, "ticket-width", 3, "Specify the number of digits of the tickets, zero padded. Default 3")
+	flag.BoolVar(&strictBinding, "strict", false, "Reject request bodies with unknown fields. Default false")
+	flag.BoolVar(&deleteIdempotent, "delete-idempotent", false, "Reply 204 instead of 404 deleting a resource that doesn't exist. Default false")
+	flag.BoolVar(&trailingSlash, "redirect-trailing-slash", true, "Redirect the paths with a trailing slash, or without, to the route matching them, otherwise they are not found. Default true")
+	flag.BoolVar(&caseFoldPaths, "case-insensitive-paths", false, "Redirect the paths differing only in case, e.g. /api/v1/Queue, to the route matching them. Default false")
+	flag.DurationVar(&avgWait, "avg-wait", 5*time.Minute, "Specify the average time to serve a party, used for the wait estimates. Default 5m")
+	flag.StringVar(&localesDir, "locales-dir", "", "Specify a directory with <lang>.json message bundles overriding the builtin ones. Default none")
+	flag.StringVar(&defaultLocale, "default-locale", "en", "Specify the language used when the client doesn't accept any available one. Default en")
+	flag.DurationVar(&heartbeat, "sse-heartbeat", 15*time.Second, "Specify how often a keepalive comment is sent on the event streams so the proxies don't close them, 0 disables it. Default 15s")
+	flag.DurationVar(&maxIdle, "sse-max-idle", 0, "Specify how long an event stream can go without events before it is closed, 0 is unlimited. Default 0")
+	flag.IntVar(&maxSubscribers, "max-subscribers", 100, "Specify the maximum number of event streams per queue, 0 is unlimited. Default 100")
+	flag.DurationVar(&slaInterval, "sla-interval", 30*time.Second, "Specify how often the queues SLA are checked. Default 30s")
+	flag.DurationVar(&requestTimeout, "request-timeout", 10*time.Second, "Specify the deadline of the requests, the notifications of a new reservation not queued by then are skipped, 0 is unlimited. The event streams are not limited. Default 10s")
+	flag.DurationVar(&notifyInterval, "notify-interval", 30*time.Second, "Specify how often the reservations to notify are checked. Default 30s")
+	flag.DurationVar(&sampleInterval, "position-sample-interval", time.Minute, "Specify how often the positions of the waiting reservations are sampled for their position history, 0 disables it. Default 1m")
+	flag.StringVar(&joinLinkSecret, "join-link-secret", "", "Enable signed join links using this secret. Default disabled")
+	flag.DurationVar(&joinLinkTTL, "join-link-ttl", 24*time.Hour, "Specify how long the signed join links are valid. Default 24h")
+	flag.StringVar(&checkinSecret, "checkin-secret", "", "Enable the check-ins with the signed tokens returned with the new reservations using this secret. Default disabled")
+	flag.DurationVar(&checkinTTL, "checkin-ttl", 24*time.Hour, "Specify how long the check-in tokens are valid. Default 24h")
+	flag.BoolVar(&singleActive, "single-active-queue", false, "Allow a phone to be waiting in only one queue at a time. Default false")
+	flag.StringVar(&metricsLabels, "metrics-queue-labels", "", "Specify the queues the per queue metrics are labeled with: empty aggregates them, all labels every queue or a comma separated list of queue ids labels those and aggregates the rest. Default empty")
+	flag.StringVar(&logFormat, "log-format", "text", "Specify the format of the logs: text or json, one object per line. Default text")
+	flag.IntVar(&logBufferSize, "log-buffer-size", 1000, "Specify the number of request logs kept in memory for the admin logs stream. Default 1000")
+	flag.IntVar(&queueCacheSize, "queue-cache-size", 64, "Specify the number of queues cached in memory, 0 disables the cache. Default 64")
+	flag.DurationVar(&holdTimeout, "hold-timeout", 5*time.Minute, "Specify how long a reservation is held when no timeout is given. Default 5m")
+	flag.IntVar(&maxNameLength, "max-name-length", 100, "Specify the maximum length of the reservation names, 0 is unlimited. Default 100")
+	flag.IntVar(&maxPhoneLength, "max-phone-length", 20, "Specify the maximum length of the reservation phones, 0 is unlimited. Default 20")
+	flag.IntVar(&maxAttachments, "max-attachments", 5, "Specify the maximum number of attachments of a reservation, 0 is unlimited. Default 5")
+	flag.IntVar(&maxAttachmentLen, "max-attachment-length", 500, "Specify the maximum length of the attachment references, 0 is unlimited. Default 500")
+	flag.StringVar(&adminToken, "admin-token", "", "Require this bearer token for the admin endpoints. Default none, the admin endpoints are disabled")
+	flag.StringVar(&observerToken, "observer-token", "", "Specify a bearer token reading every queue, with the phones masked, but not changing anything. Default none")
+	flag.StringVar(&phoneCountryCode, "phone-country-code", "", "Specify the country code, e.g. 34, of the phones typed without one, so they are stored in E.164 like the others. Default none, they are kept national")
+	flag.StringVar(&duplicatePhone, "duplicate-phone", "reject", "Specify what joining with a phone already waiting in the queue does: reject it, allow a second reservation, replace the existing one or merge the new party into it. Default reject")
+	flag.StringVar(&duplicateMove, "duplicate-phone-position", "back", "Specify if the reservations replaced by -duplicate-phone=replace move to the back or keep their position. Default back")
+	flag.Float64Var(&priorityAging, "priority-aging-rate", 0, "Specify the priority per hour waited the parties gain when calling the next one, a priority reservation is worth 1 so with 2 a party waiting 30m out-ranks a fresh priority one, 0 calls them in position order. Default 0")
+	flag.StringVar(&anonymizeKey, "anonymize-key", "", "Specify the key the names and phones of the anonymized exports are hashed with, so their tokens are the same across restarts. Default a random key per run")
+	flag.StringVar(&tableFit, "table-fit", "greedy", "Specify how calling the next parties for a table of ?table= seats combines them: greedy takes them in order while they fit, best fills the most seats. Default greedy")
+	flag.IntVar(&deferSpots, "self-defer-spots", 3, "Specify how many spots at most the parties can push their reservations back themselves, 0 disables it. Default 3")
+	flag.IntVar(&maxDefers, "self-defer-max", 2, "Specify how many times a reservation can be pushed back by its party, 0 is unlimited. Default 2")
+	flag.DurationVar(&deferInterval, "self-defer-interval", time.Minute, "Specify how long a party waits between pushing its reservation back. Default 1m")
+	flag.StringVar(&vipBypass, "capacity-vip-bypass", "off", "Specify what a priority reservation does in a full queue: off rejects it, exceed joins over the capacity and displace removes the last party without priority. Default off")
+	flag.IntVar(&positionBase, "position-base", 1, "Specify if the positions are reported 1-based or 0-based, the first party is at this position. Default 1")
+	flag.StringVar(&anonymousName, "anonymous-name", "Ticket {{.Number}}", "Specify the template of the names of the reservations without name, empty keeps them empty. Default \"Ticket {{.Number}}\"")
+	flag.StringVar(&returnURLHosts, "return-url-hosts", "", "Specify the comma separated hosts the reservation return URLs can point to. Default none")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 10*time.Second, "Specify how long the open connections are drained on shutdown before closing the event streams. Default 10s")
+	flag.DurationVar(&killTimeout, "kill-timeout", 2*time.Second, "Specify how long the event streams have to close after the drain timeout before all the connections are closed. Default 2s")
+	flag.BoolVar(&prometheus, "prometheus", true, "Expose the metrics in the Prometheus format on /metrics. Default true")
+	flag.StringVar(&statsdAddr, "statsd-addr", "", "Specify the host:port of a StatsD server where the metrics are pushed. Default disabled")
+	flag.StringVar(&statsdPrefix, "statsd-prefix", "cola.", "Specify the prefix of the metrics pushed to StatsD. Default cola.")
+	flag.DurationVar(&statsdInterval, "statsd-interval", 10*time.Second, "Specify how often the metrics are pushed to StatsD. Default 10s")
 
 }
 
@@ -30,37 +161,420 @@ PRAGMA foreign_keys = ON;
 
 CREATE TABLE IF NOT EXISTS queue (
 	id INTEGER PRIMARY KEY,
-	name TEXT NOT NULL UNIQUE
+	name TEXT NOT NULL UNIQUE,
+	sla_seconds INTEGER NOT NULL DEFAULT 0,
+	ticket_prefix TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	rate_limit INTEGER NOT NULL DEFAULT 0,
+	notify_lead_seconds INTEGER NOT NULL DEFAULT 0,
+	capacity INTEGER,
+	capacity_by TEXT NOT NULL DEFAULT 'parties',
+	paused BOOLEAN NOT NULL DEFAULT 0,
+	require_confirmation BOOLEAN NOT NULL DEFAULT 0,
+	strategy TEXT NOT NULL DEFAULT 'fifo',
+	start_number INTEGER NOT NULL DEFAULT 1,
+	opens_at TEXT NOT NULL DEFAULT '',
+	closes_at TEXT NOT NULL DEFAULT '',
+	min_group_size INTEGER NOT NULL DEFAULT 0,
+	max_group_size INTEGER NOT NULL DEFAULT 0,
+	color TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	required_fields TEXT NOT NULL DEFAULT 'phone',
+	category TEXT NOT NULL DEFAULT '',
+	position_band_size INTEGER NOT NULL DEFAULT 0,
+	closed_at DATETIME
 );
 
-CREATE TABLE IF NOT EXISTS reservation (
+CREATE TABLE IF NOT EXISTS reservation ` + reservationTable + `;
+
+CREATE TABLE IF NOT EXISTS phone_history (
+	id INTEGER PRIMARY KEY,
+	reservationid INTEGER NOT NULL,
+	phone TEXT NOT NULL,
+	changed_at DATETIME,
+	FOREIGN KEY (reservationid) REFERENCES reservation (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS phone_history_phone ON phone_history (phone);
+
+CREATE TABLE IF NOT EXISTS served (
 	id INTEGER PRIMARY KEY,
+	reservationid INTEGER NOT NULL,
 	queueid INTEGER,
-	position INTEGER,
+	number INTEGER,
 	name TEXT NOT NULL,
-	phone TEXT NOT NULL UNIQUE,
+	phone TEXT NOT NULL,
 	groupsize INTEGER,
+	created_at DATETIME,
+	served_at DATETIME,
+	FOREIGN KEY (queueid) REFERENCES queue (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS served_queue_served_at ON served (queueid, served_at);
+
+CREATE TABLE IF NOT EXISTS audit (
+	id INTEGER PRIMARY KEY,
+	queueid INTEGER,
+	reservationid INTEGER,
+	action TEXT NOT NULL,
+	detail TEXT,
+	created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS audit_reservation ON audit (reservationid);
+
+CREATE TABLE IF NOT EXISTS position_sample (
+	id INTEGER PRIMARY KEY,
+	reservationid INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	sampled_at DATETIME,
+	FOREIGN KEY (reservationid) REFERENCES reservation (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS position_sample_reservation ON position_sample (reservationid);
+
+CREATE TABLE IF NOT EXISTS position_trigger (
+	id INTEGER PRIMARY KEY,
+	reservationid INTEGER NOT NULL UNIQUE,
+	position INTEGER NOT NULL,
+	webhook TEXT NOT NULL,
+	created_at DATETIME,
+	FOREIGN KEY (reservationid) REFERENCES reservation (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS counter (
+	name TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS queue_hook (
+	queueid INTEGER PRIMARY KEY,
+	secret TEXT NOT NULL,
 	FOREIGN KEY (queueid) REFERENCES queue (id) ON DELETE CASCADE
 );
 `
 
+// reservationTable is the definition of the reservation table, it is kept
+// apart so the table can be rebuilt on migrations.
+const reservationTable = `(
+	id INTEGER PRIMARY KEY,
+	queueid INTEGER,
+	position INTEGER,
+	name TEXT NOT NULL,
+	phone TEXT NOT NULL,
+	groupsize INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sla_breached_at DATETIME,
+	number INTEGER,
+	parentid INTEGER,
+	notify_lead_seconds INTEGER,
+	notified_at DATETIME,
+	confirmation_code TEXT NOT NULL DEFAULT '',
+	held_until DATETIME,
+	scheduled_at DATETIME,
+	return_url TEXT NOT NULL DEFAULT '',
+	email TEXT NOT NULL DEFAULT '',
+	seq INTEGER,
+	priority BOOLEAN NOT NULL DEFAULT 0,
+	locale TEXT NOT NULL DEFAULT '',
+	checked_in_at DATETIME,
+	attachments TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'waiting',
+	defer_count INTEGER NOT NULL DEFAULT 0,
+	deferred_at DATETIME,
+	FOREIGN KEY (queueid) REFERENCES queue (id) ON DELETE CASCADE
+)`
+
+// indexes depending on migrated columns, created once the migrations ran.
+// A phone can wait in several queues, whether it can wait more than once in
+// the same queue depends on -duplicate-phone so it isn't unique here.
+// The seq of the new reservations comes from a counter so it is never
+// reused, not even once the reservation with the last one is gone, and it
+// is kept by the rows inserted with one, e.g. when restoring them.
+const indexes = `
+DROP INDEX IF EXISTS reservation_phone;
+DROP INDEX IF EXISTS reservation_queue_phone;
+DROP INDEX IF EXISTS reservation_queue_phone_given;
+CREATE INDEX IF NOT EXISTS reservation_queue_phone_lookup ON reservation (queueid, phone);
+CREATE INDEX IF NOT EXISTS reservation_queue_status ON reservation (queueid, status);
+CREATE UNIQUE INDEX IF NOT EXISTS reservation_seq ON reservation (seq);
+INSERT OR IGNORE INTO counter (name, value) SELECT 'reservation_seq', COALESCE(MAX(seq), 0) FROM reservation;
+CREATE TRIGGER IF NOT EXISTS reservation_seq AFTER INSERT ON reservation WHEN NEW.seq IS NULL
+BEGIN
+	UPDATE counter SET value = MAX(value, COALESCE((SELECT MAX(seq) FROM reservation), 0)) + 1 WHERE name = 'reservation_seq';
+	UPDATE reservation SET seq = (SELECT value FROM counter WHERE name = 'reservation_seq') WHERE id = NEW.id;
+END;
+`
+
+// migrations add the columns introduced after the initial schema to
+// databases created by older versions, backfill runs once the column exists.
+var migrations = []struct {
+	table      string
+	column     string
+	definition string
+	backfill   string
+}{
+	{"queue", "sla_seconds", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"reservation", "created_at", "DATETIME", "UPDATE reservation SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL"},
+	{"reservation", "sla_breached_at", "DATETIME", ""},
+	{"queue", "ticket_prefix", "TEXT NOT NULL DEFAULT ''", ""},
+	{"reservation", "number", "INTEGER", "UPDATE reservation SET number = position WHERE number IS NULL"},
+	{"queue", "created_at", "DATETIME", "UPDATE queue SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL"},
+	{"queue", "rate_limit", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"reservation", "parentid", "INTEGER", ""},
+	{"queue", "notify_lead_seconds", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"queue", "capacity", "INTEGER", ""},
+	{"queue", "paused", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"queue", "require_confirmation", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"queue", "strategy", "TEXT NOT NULL DEFAULT 'fifo'", ""},
+	{"queue", "start_number", "INTEGER NOT NULL DEFAULT 1", ""},
+	{"queue", "opens_at", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "closes_at", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "min_group_size", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"queue", "max_group_size", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"queue", "color", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "description", "TEXT NOT NULL DEFAULT ''", ""},
+	{"reservation", "notify_lead_seconds", "INTEGER", ""},
+	{"reservation", "notified_at", "DATETIME", ""},
+	{"reservation", "confirmation_code", "TEXT NOT NULL DEFAULT ''", ""},
+	{"reservation", "held_until", "DATETIME", ""},
+	{"reservation", "scheduled_at", "DATETIME", ""},
+	{"reservation", "return_url", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "required_fields", "TEXT NOT NULL DEFAULT 'phone'", ""},
+	{"reservation", "email", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "category", "TEXT NOT NULL DEFAULT ''", ""},
+	{"queue", "position_band_size", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"reservation", "seq", "INTEGER", `UPDATE reservation SET seq = (SELECT COUNT(*) FROM reservation r
+		WHERE r.created_at < reservation.created_at OR (r.created_at = reservation.created_at AND r.id <= reservation.id))`},
+	{"reservation", "priority", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"reservation", "locale", "TEXT NOT NULL DEFAULT ''", ""},
+	{"reservation", "checked_in_at", "DATETIME", ""},
+	{"queue", "closed_at", "DATETIME", ""},
+	{"reservation", "attachments", "TEXT NOT NULL DEFAULT ''", ""},
+	{"reservation", "status", "TEXT NOT NULL DEFAULT 'waiting'", ""},
+	{"reservation", "defer_count", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"reservation", "deferred_at", "DATETIME", ""},
+	{"queue", "capacity_by", "TEXT NOT NULL DEFAULT 'parties'", ""},
+}
+
+func migrate(db *sqlx.DB) error {
+	for _, m := range migrations {
+		var n int
+		err := db.Get(&n, "SELECT COUNT(*) FROM pragma_table_info($1) WHERE name=$2", m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + m.table + " ADD COLUMN " + m.column + " " + m.definition); err != nil {
+			return err
+		}
+		if m.backfill != "" {
+			if _, err := db.Exec(m.backfill); err != nil {
+				return err
+			}
+		}
+	}
+	// older versions declared the phone UNIQUE inline
+	var inline int
+	err := db.Get(&inline, "SELECT COUNT(*) FROM pragma_index_list('reservation') WHERE origin='u'")
+	if err != nil {
+		return err
+	}
+	if inline > 0 {
+		if err := rebuildReservation(db); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(indexes)
+	return err
+}
+
+// rebuildReservation recreates the reservation table with the current
+// definition keeping its rows, SQLite can't drop constraints in place.
+func rebuildReservation(db *sqlx.DB) error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// otherwise dropping the old table cascades to the tables referencing it
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	var columns []string
+	err = conn.SelectContext(ctx, &columns, "SELECT name FROM pragma_table_info('reservation')")
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"CREATE TABLE reservation_new " + reservationTable,
+		"INSERT INTO reservation_new (" + strings.Join(columns, ", ") + ") SELECT " + strings.Join(columns, ", ") + " FROM reservation",
+		"DROP TABLE reservation",
+		"ALTER TABLE reservation_new RENAME TO reservation",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// servingOrder is the ORDER BY clause that sorts the reservations in the
+// order they are going to be served. The queues in appointment mode serve
+// first the appointments by their scheduled time, then the walk-ins. The
+// ties of position are broken by seq, the order the reservations joined in,
+// the id isn't used as it may change, e.g. across a restore.
+const servingOrder = `CASE WHEN (SELECT strategy FROM queue WHERE queue.id = reservation.queueid) = 'appointment'
+	THEN scheduled_at END ASC NULLS LAST, position ASC, seq ASC`
+
+// selectReservations selects the reservations of the queue $1 together with
+// their group position and their person position, the number of people up
+// to and including the reservation, both computed in serving order.
+const selectReservations = `SELECT * FROM (SELECT *,
+	ROW_NUMBER() OVER (ORDER BY ` + servingOrder + `) AS group_position,
+	SUM(groupsize) OVER (ORDER BY ` + servingOrder + ` ROWS UNBOUNDED PRECEDING) AS person_position
+	FROM reservation WHERE queueid=$1 AND status='waiting')`
+
 type Queue struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name" binding:"omitempty,min=8"`
+	ID        int64     `json:"id"`
+	Name      string    `json:"name" binding:"omitempty,min=8"`
+	CreatedAt time.Time `json:"created_at"`
+	// ClosedAt is when the staff closed the queue, until it is unpaused
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+	QueueConfig
 }
 
 type Reservation struct {
-	ID        int64  `json:"id"`
-	QueueID   int64  `json:"queueid,omitempty"`
-	Queue     Queue  `json:"queue,omitempty"`
-	Position  int64  `json:"position,omitempty"`
-	Name      string `json:"name" binding:"required,min=8"`
-	Phone     string `json:"phone" binding:"required,min=9"`
-	GroupSize int64  `json:"groupsize"`
+	ID            int64      `json:"id"`
+	QueueID       int64      `json:"queueid,omitempty"`
+	Queue         Queue      `json:"queue,omitempty"`
+	Position      int64      `json:"position"`
+	Name          string     `json:"name" binding:"omitempty,min=8"`
+	Phone         string     `json:"phone" binding:"omitempty,min=9"`
+	Email         string     `json:"email,omitempty" binding:"omitempty,email"`
+	GroupSize     int64      `json:"groupsize"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SLABreachedAt *time.Time `json:"sla_breached_at,omitempty"`
+	// Number is assigned sequentially per queue and never changes
+	Number int64 `json:"number,omitempty"`
+	// ParentID is the reservation this party was split from
+	ParentID *int64 `json:"parentid,omitempty"`
+	// NotifyLeadSeconds overrides the notification lead time of the queue
+	NotifyLeadSeconds *int64     `json:"notify_lead_seconds,omitempty" binding:"omitempty,min=0"`
+	NotifiedAt        *time.Time `json:"notified_at,omitempty"`
+	// ConfirmationCode identifies the party when served, it is only
+	// returned when the reservation is created
+	ConfirmationCode string `json:"confirmation_code,omitempty"`
+	// HeldUntil skips the reservation when calling the next party, keeping
+	// its position, while a host is seating it
+	HeldUntil *time.Time `json:"held_until,omitempty"`
+	// ScheduledAt is the time of the appointment, if any
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	// ReturnURL is where the web flows redirect back to once confirmed
+	ReturnURL string `json:"return_url,omitempty"`
+	// Seq is the order the reservation joined in, it breaks the ties of
+	// position and it is never reused
+	Seq int64 `json:"seq,omitempty"`
+	// Priority reservations can be let into the full queues, depending on
+	// -capacity-vip-bypass, only the staff can create them
+	Priority bool `json:"priority,omitempty"`
+	// Locale is the language of the messages to the party, empty is the
+	// server default
+	Locale string `json:"locale,omitempty"`
+	// CheckedInAt is when the party confirmed its arrival
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+	// Attachments reference photos or receipts stored elsewhere
+	Attachments attachmentList `json:"attachments,omitempty"`
+	// Status is waiting until the party leaves the queue, the reservations
+	// served, cancelled or not showing up are kept
+	Status string `json:"status"`
+	// DeferCount is how many times the party pushed itself back, the last
+	// one at DeferredAt
+	DeferCount int64      `json:"defer_count,omitempty"`
+	DeferredAt *time.Time `json:"deferred_at,omitempty"`
+	// computed, not stored
+	GroupPosition        int64      `json:"group_position"`
+	PersonPosition       int64      `json:"person_position"`
+	Ticket               string     `json:"ticket,omitempty"`
+	EstimatedWaitSeconds int64      `json:"estimated_wait_seconds"`
+	EstimatedReadyAt     *time.Time `json:"estimated_ready_at,omitempty"`
+	// CheckinToken is only returned when the reservation is created
+	CheckinToken string `json:"checkin_token,omitempty"`
+	// Notification is also only returned when the reservation is created,
+	// queued or skipped depending on whether the party will be notified
+	Notification string `json:"notification,omitempty"`
+}
+
+// apiError is the body returned by the handlers on failure, the code is
+// stable and meant for clients, the message is meant for humans.
+type apiError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+	// Fields lists the offending request fields, if any
+	Fields []string `json:"fields,omitempty"`
+	// Limit is the maximum exceeded, if any
+	Limit int `json:"limit,omitempty"`
 }
 
 func main() {
 	flag.Parse()
+	if duplicatePhone != "reject" && duplicatePhone != "allow" && duplicatePhone != "replace" && duplicatePhone != "merge" {
+		log.Fatalf("Invalid -duplicate-phone %q, it must be reject, allow, replace or merge", duplicatePhone)
+	}
+	if _, err := parseQueueLabels(metricsLabels); err != nil {
+		log.Fatalf("Invalid -metrics-queue-labels %q: %v", metricsLabels, err)
+	}
+	if logFormat != "text" && logFormat != "json" {
+		log.Fatalf("Invalid -log-format %q, it must be text or json", logFormat)
+	}
+	if cc := phoneCountryCode; cc != "" && (len(cc) > 3 || cc[0] == '0' || strings.Trim(cc, "0123456789") != "") {
+		log.Fatalf("Invalid -phone-country-code %q, it must be 1 to 3 digits without the +", phoneCountryCode)
+	}
+	if duplicateMove != "back" && duplicateMove != "keep" {
+		log.Fatalf("Invalid -duplicate-phone-position %q, it must be back or keep", duplicateMove)
+	}
+	if databaseKey != "" && !encryptionSupported {
+		log.Fatalf("Invalid -db-key, this build doesn't support encryption, build it with -tags sqlcipher")
+	}
+	if positionBase != 0 && positionBase != 1 {
+		log.Fatalf("Invalid -position-base %d, it must be 0 or 1", positionBase)
+	}
+	if priorityAging < 0 {
+		log.Fatalf("Invalid -priority-aging-rate %v, it can not be negative", priorityAging)
+	}
+	if defaultQueue != "" {
+		if err := binding.Validator.ValidateStruct(&Queue{Name: defaultQueue}); err != nil {
+			log.Fatalf("Invalid -default-queue %q: %v", defaultQueue, err)
+		}
+	}
+	if observerToken != "" && observerToken == adminToken {
+		log.Fatalf("Invalid -observer-token, it can not be the -admin-token")
+	}
+	if busyTimeout < 0 || maxOpenConns < 0 {
+		log.Fatalf("Invalid -db-busy-timeout %v or -db-max-open-conns %d, they can not be negative", busyTimeout, maxOpenConns)
+	}
+	if requestTimeout < 0 {
+		log.Fatalf("Invalid -request-timeout %v, it can not be negative", requestTimeout)
+	}
+	if tableFit != "greedy" && tableFit != "best" {
+		log.Fatalf("Invalid -table-fit %q, it must be greedy or best", tableFit)
+	}
+	if deferSpots < 0 || maxDefers < 0 {
+		log.Fatalf("Invalid -self-defer-spots %d or -self-defer-max %d, they can not be negative", deferSpots, maxDefers)
+	}
+	if vipBypass != "off" && vipBypass != "exceed" && vipBypass != "displace" {
+		log.Fatalf("Invalid -capacity-vip-bypass %q, it must be off, exceed or displace", vipBypass)
+	}
 	// trap Ctrl+C and call cancel on the context
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -82,181 +596,996 @@ func main() {
 		}
 	}()
 	app := NewApp(database)
+	// the messages of the log package go to the same JSON lines
+	if app.jsonLog != nil {
+		log.SetFlags(0)
+		log.SetOutput(app.jsonLog)
+	}
 	app.Run(ctx)
 }
 
 type App struct {
-	mu     sync.Mutex
-	router *gin.Engine
-	db     *sqlx.DB
+	mu      sync.Mutex
+	router  *gin.Engine
+	db      *sqlx.DB
+	metrics *metrics
+	// now is the clock used for timestamps, replaceable in tests
+	now func() time.Time
+	// listeners receive every event emitted by the app
+	listeners []func(Event)
+	// auditSink receives every audit entry besides the database, nil is none
+	auditSink func(AuditEntry)
+	// auditPending are the entries for the sink of the transactions not
+	// committed yet
+	auditMu      sync.Mutex
+	auditPending map[*sqlx.Tx][]AuditEntry
+	// slaInterval is how often waiting reservations are checked against the SLA
+	slaInterval time.Duration
+	// startedAt is when the app was created, for the uptime
+	startedAt time.Time
+	// adminToken is the bearer token of the admin endpoints, empty disables
+	// them
+	adminToken string
+	// observerToken is the bearer token of the read only access, empty
+	// disables it
+	observerToken string
+	// getJoinToken enables the GET join endpoint when not empty
+	getJoinToken string
+	// joinLinkSecret signs the join links valid for joinLinkTTL, empty disables them
+	joinLinkSecret string
+	joinLinkTTL    time.Duration
+	// checkinSecret signs the check-in tokens valid for checkinTTL, empty
+	// disables them
+	checkinSecret string
+	checkinTTL    time.Duration
+	// ticketWidth is the zero padded width of the ticket numbers
+	ticketWidth int
+	// strictBinding rejects request bodies with unknown fields
+	strictBinding bool
+	// deleteIdempotent replies 204 deleting a missing resource instead of 404
+	deleteIdempotent bool
+	// maxNameLength and maxPhoneLength limit the reservation fields
+	maxNameLength  int
+	maxPhoneLength int
+	// maxAttachments and maxAttachmentLength limit the attachments of the
+	// reservations
+	maxAttachments      int
+	maxAttachmentLength int
+	// returnURLHosts are the hosts allowed in the return URLs
+	returnURLHosts map[string]bool
+	// positionBase is the position the first party is reported at, they
+	// are stored 1-based
+	positionBase int64
+	// anonymousName names the reservations without name, nil keeps them empty
+	anonymousName *template.Template
+	// phoneCountryCode is the country code of the phones typed without one
+	phoneCountryCode string
+	// singleActive rejects the phones already waiting in another queue
+	singleActive bool
+	// duplicatePhone is what joining with a phone already waiting in the
+	// queue does: reject, allow, replace or merge
+	duplicatePhone string
+	// replaceKeepsPosition keeps the position of the replaced reservations
+	// instead of moving them to the back
+	replaceKeepsPosition bool
+	// vipBypass is what a priority reservation does in a full queue: off,
+	// exceed or displace
+	vipBypass string
+	// priorityAging is the priority per hour waited, calling the next party
+	// the priority reservations go first unless the others waited enough
+	priorityAging float64
+	// anonymizeKey keys the tokens replacing the names and the phones of the
+	// anonymized exports
+	anonymizeKey []byte
+	// tableFit is how the parties are combined for a table: greedy or best
+	tableFit string
+	// deferSpots is how many spots the parties can push themselves back, 0
+	// disables it, up to maxDefers times, 0 is unlimited, and once every
+	// deferInterval
+	deferSpots    int64
+	maxDefers     int64
+	deferInterval time.Duration
+	// avgWait is the average time it takes to serve a party
+	avgWait time.Duration
+	// holdTimeout is the default time a reservation is held
+	holdTimeout time.Duration
+	// queueCache holds the most recently read queues
+	queueCache *queueCache
+	// logs keeps the last request logs for the admin logs stream
+	logs *logBuffer
+	// jsonLog writes the request logs as JSON lines, nil logs them as text
+	jsonLog *jsonLog
+	// queueLabels are the queues labeled in the per queue metrics
+	queueLabels queueLabels
+	// dbDir is the directory of the database file, empty in memory
+	dbDir string
+	// minFreeDisk is the free space, in bytes, /readyz requires in dbDir
+	minFreeDisk uint64
+	// freeDiskSpace returns the bytes available in a directory
+	freeDiskSpace func(dir string) (uint64, error)
+	// queueLimiter throttles the reservations of the queues with a rate limit
+	queueLimiter *queueLimiter
+	// i18n renders the customer facing messages
+	i18n *translator
+	// hub delivers the events to the streams subscribed to the queues
+	hub *hub
+	// heartbeatInterval is how often the streams send a keepalive comment,
+	// maxIdle closes the event streams without events for that long
+	heartbeatInterval time.Duration
+	maxIdle           time.Duration
+	// notifier delivers the notifications to the parties
+	notifier notifier
+	// notifications queues the notifications delivered in the background
+	notifications chan Notification
+	// notifyInterval is how often the reservations to notify are checked
+	notifyInterval time.Duration
+	// requestTimeout is the deadline of the requests, 0 is unlimited
+	requestTimeout time.Duration
+	// sampleInterval is how often the positions are sampled for their history
+	sampleInterval time.Duration
+	// drainTimeout is the graceful shutdown deadline, once exceeded the
+	// connections left are closed after killTimeout
+	drainTimeout time.Duration
+	killTimeout  time.Duration
+	// statsd pushes the metrics every statsdInterval when not nil
+	statsd         *statsd
+	statsdInterval time.Duration
 }
 
 func NewApp(dbname string) *App {
-	a := &App{}
+	a := &App{
+		metrics:              newMetrics(),
+		now:                  time.Now,
+		startedAt:            time.Now(),
+		adminToken:           adminToken,
+		observerToken:        observerToken,
+		slaInterval:          slaInterval,
+		getJoinToken:         getJoinToken,
+		joinLinkSecret:       joinLinkSecret,
+		joinLinkTTL:          joinLinkTTL,
+		checkinSecret:        checkinSecret,
+		checkinTTL:           checkinTTL,
+		ticketWidth:          ticketWidth,
+		positionBase:         int64(positionBase),
+		strictBinding:        strictBinding,
+		deleteIdempotent:     deleteIdempotent,
+		singleActive:         singleActive,
+		duplicatePhone:       duplicatePhone,
+		phoneCountryCode:     phoneCountryCode,
+		replaceKeepsPosition: duplicateMove == "keep",
+		vipBypass:            vipBypass,
+		priorityAging:        priorityAging,
+		tableFit:             tableFit,
+		deferSpots:           int64(deferSpots),
+		maxDefers:            int64(maxDefers),
+		deferInterval:        deferInterval,
+		maxNameLength:        maxNameLength,
+		maxPhoneLength:       maxPhoneLength,
+		maxAttachments:       maxAttachments,
+		maxAttachmentLength:  maxAttachmentLen,
+		avgWait:              avgWait,
+		holdTimeout:          holdTimeout,
+		queueLimiter:         newQueueLimiter(),
+		queueCache:           newQueueCache(queueCacheSize),
+		logs:                 newLogBuffer(logBufferSize),
+		minFreeDisk:          minFreeDiskMB << 20,
+		freeDiskSpace:        freeDiskSpace,
+		hub:                  newHub(maxSubscribers),
+		heartbeatInterval:    heartbeat,
+		maxIdle:              maxIdle,
+		notifications:        make(chan Notification, notificationBuffer),
+		notifyInterval:       notifyInterval,
+		requestTimeout:       requestTimeout,
+		sampleInterval:       sampleInterval,
+		statsdInterval:       statsdInterval,
+		drainTimeout:         drainTimeout,
+		killTimeout:          killTimeout,
+	}
+	a.notifier = &eventNotifier{app: a}
+	a.anonymizeKey = []byte(anonymizeKey)
+	if anonymizeKey == "" {
+		a.anonymizeKey = make([]byte, 32)
+		if _, err := rand.Read(a.anonymizeKey); err != nil {
+			panic(err)
+		}
+	}
+	// validated in main
+	a.queueLabels, _ = parseQueueLabels(metricsLabels)
+	if anonymousName != "" {
+		a.anonymousName = template.Must(template.New("anonymous-name").Parse(anonymousName))
+	}
+	a.returnURLHosts = map[string]bool{}
+	for _, h := range strings.Split(returnURLHosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			a.returnURLHosts[h] = true
+		}
+	}
+	a.listeners = append(a.listeners, a.hub.publish, a.triggerListener)
+	a.metrics.gauge("cola_subscribers", "Number of open event streams per queue.", func() map[string]float64 {
+		return a.queueLabels.samples(a.hub.counts())
+	})
+	if webhookURL != "" {
+		a.listeners = append(a.listeners, newWebhook(webhookURL))
+	}
+	if auditSinkSpec != "" {
+		sink, err := newAuditSink(auditSinkSpec)
+		if err != nil {
+			panic(fmt.Errorf("can not open the audit sink %s: %w", auditSinkSpec, err))
+		}
+		a.auditSink = sink
+	}
+	if statsdAddr != "" {
+		s, err := newStatsd(statsdAddr, statsdPrefix)
+		if err != nil {
+			panic(fmt.Errorf("can not connect to StatsD %s: %w", statsdAddr, err))
+		}
+		a.statsd = s
+	}
+	i18n, err := newTranslator(localesDir, defaultLocale)
+	if err != nil {
+		panic(err)
+	}
+	a.i18n = i18n
 	// database
-	_db, err := sqlx.Connect("sqlite3", dbname)
+	dbname, err = prepareDatabasePath(dbname, os.FileMode(databaseMode))
+	if err != nil {
+		panic(err)
+	}
+	dsn, err := withDatabaseKey(dbname, databaseKey)
 	if err != nil {
 		panic(err)
 	}
+	_db, err := sqlx.Connect("sqlite3", withConnectionPragmas(dsn, busyTimeout))
+	if err != nil {
+		panic(fmt.Errorf("can not open the database %s: %w", dbname, err))
+	}
+	_db.SetMaxOpenConns(maxOpenConns)
 	a.db = _db
+	a.dbDir = databaseDir(dbname)
 	a.db.Mapper = reflectx.NewMapperFunc("json", strings.ToLower)
 	a.db.MustExec(schema)
+	if err := migrate(a.db); err != nil {
+		panic(err)
+	}
+	if defaultQueue != "" {
+		if err := a.createDefaultQueue(defaultQueue); err != nil {
+			panic(fmt.Errorf("can not create the default queue %s: %w", defaultQueue, err))
+		}
+	}
 	// API
-	a.router = gin.Default()
-	v1 := a.router.Group("/api/v1")
+	a.router = gin.New()
+	a.router.Use(gin.Recovery())
+	if logFormat == "json" {
+		a.jsonLog = newJSONLog(os.Stderr)
+	} else {
+		a.router.Use(gin.Logger())
+	}
+	a.router.RedirectTrailingSlash = trailingSlash
+	a.router.RedirectFixedPath = caseFoldPaths
+	a.router.Use(a.countRequests, a.logRequests, a.requestDeadline, a.observerAccess)
+	v1 := a.router.Group("/api/v1", validQueueID)
 	{
 		// queues
 		v1.POST("/queue", a.createQueue)
 		v1.GET("/queue", a.getAllQueues)
+		v1.GET("/dashboard", a.getDashboard)
 		v1.GET("/queue/:id", a.getSingleQueue)
 		v1.PUT("/queue/:id", a.updateQueue)
+		v1.PATCH("/queue/:id", a.patchQueue)
+		v1.GET("/queue/:id/config", a.getQueueConfig)
+		v1.PUT("/queue/:id/config", a.putQueueConfig)
 		v1.DELETE("/queue/:id", a.deleteQueue)
+		v1.GET("/queue/:id/stats", a.getQueueStats)
+		v1.GET("/queue/:id/events", a.streamEvents)
+		v1.GET("/queue/:id/estimate", a.getEstimate)
 		// reservations
 		v1.POST("/queue/:id/reservation", a.createReservation)
 		v1.GET("/queue/:id/reservation", a.getAllReservations)
+		v1.DELETE("/queue/:id/reservation", a.clearReservations)
+		v1.GET("/queue/:id/reservation/count", a.countReservations)
+		v1.HEAD("/queue/:id/reservation/count", a.countReservations)
+		v1.POST("/queue/:id/reservation/bulk-status", a.bulkStatus)
 		v1.GET("/queue/:id/reservation/:rsvp", a.getSingleReservation)
 		v1.PUT("/queue/:id/reservation/:rsvp", a.updateReservation)
 		v1.DELETE("/queue/:id/reservation/:rsvp", a.deleteReservation)
+		v1.GET("/queue/:id/reservation/:rsvp/status", a.getReservationStatus)
+		v1.GET("/queue/:id/reservation/:rsvp/qr", a.getReservationQR)
+		v1.GET("/queue/:id/reservation/:rsvp/timeline", a.getTimeline)
+		v1.GET("/queue/:id/reservation/:rsvp/position-history", a.getPositionHistory)
+		v1.POST("/queue/:id/reservation/:rsvp/notify-at", a.createPositionTrigger)
+		v1.POST("/checkin", a.checkin)
+		v1.POST("/queue/:id/reservation/:rsvp/serve", a.serveReservation)
+		v1.POST("/queue/:id/reservation/:rsvp/hold", a.holdReservation)
+		v1.POST("/queue/:id/reservation/:rsvp/release", a.releaseReservation)
+		v1.POST("/queue/:id/next", a.serveNext)
+		v1.POST("/queue/:id/close", a.closeQueue)
+		v1.GET("/queue/:id/served", a.getServed)
+		v1.GET("/queue/:id/served/export", a.exportServed)
+		v1.GET("/queue/:id/export", a.exportReservations)
+		v1.POST("/queue/:id/reservation/:rsvp/split", a.splitReservation)
+		v1.POST("/queue/:id/reservation/:rsvp/move", a.moveReservation)
+		v1.PATCH("/queue/:id/reservation/:rsvp/position", a.setReservationPosition)
+		v1.PATCH("/queue/:id/reservation/:rsvp/status", a.setReservationStatus)
+		v1.POST("/queue/:id/reservation/self/defer", a.deferReservation)
+		v1.GET("/queue/:id/search", a.searchReservations)
+		v1.GET("/queue/:id/position", a.getPositionByPhone)
+		v1.GET("/queue/:id/peek", a.peekQueue)
+		v1.GET("/queue/:id/join", a.joinReservation)
+		v1.GET("/queue/:id/join-link", a.getJoinLink)
+		v1.POST("/hooks/:queue", a.receiveHook)
+		v1.POST("/reservation/validate", a.validateReservations)
+		v1.GET(strings.TrimPrefix(openAPIPath, "/api/v1"), a.getOpenAPI)
+	}
+	admin := v1.Group("/admin", a.requireAdmin)
+	{
+		admin.GET("/metrics-summary", a.getMetricsSummary)
+		admin.GET("/duplicates", a.getDuplicates)
+		admin.GET("/logs", a.streamLogs)
+		admin.GET("/subscribers", a.getSubscribers)
+		admin.DELETE("/subscribers/:id", a.revokeSubscriber)
+		admin.POST("/queues/prune-empty", a.pruneEmptyQueues)
+		admin.POST("/queue/:id/repair-positions", a.repairPositions)
+		admin.PUT("/queue/:id/hook", a.putHook)
+		admin.DELETE("/queue/:id/hook", a.deleteHook)
 	}
 
-	a.router.GET("/healthz", func(c *gin.Context) {
-		c.String(200, "ok")
+	// tells who is answering to the people checking if the service is up
+	a.router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"service": "cola-loca", "version": version, "docs": openAPIPath})
 	})
+	a.router.GET("/healthz", a.healthz)
+	a.router.GET("/readyz", a.readyz)
+	if prometheus {
+		a.router.GET("/metrics", a.metrics.handler)
+	}
 	return a
 }
 
 func (a *App) Run(ctx context.Context) {
-	done := make(chan struct{})
+	go a.every(ctx, "SLA check", a.slaInterval, a.checkSLA)
+	go a.every(ctx, "notification check", a.notifyInterval, a.checkNotifications)
+	go a.deliverNotifications(ctx)
+	go a.every(ctx, "position sampling", a.sampleInterval, a.samplePositions)
+	if a.statsd != nil {
+		go a.every(ctx, "StatsD push", a.statsdInterval, func() error {
+			return a.statsd.push(a.metrics)
+		})
+	}
+
+	ln, err := net.Listen("tcp", ":3000")
+	if err != nil {
+		log.Printf("Error starting http server: %v", err)
+	} else if err := a.serveHTTP(ctx, ln); err != nil {
+		log.Printf("Error stopping http server: %v", err)
+	}
+	if a.statsd != nil {
+		a.statsd.Close()
+	}
+	a.db.Close()
+}
+
+// serveHTTP serves the API on ln until the context is done. It then drains
+// the connections for up to drainTimeout, once exceeded the event streams
+// are sent a goodbye event and after killTimeout the remaining connections
+// are closed.
+func (a *App) serveHTTP(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: a.router}
+	errCh := make(chan error, 1)
 	go func() {
-		err := a.router.Run(":3000")
-		if err != nil {
-			log.Printf("Error stopping http server: %v", err)
-		}
-		close(done)
+		errCh <- srv.Serve(ln)
 	}()
-
 	select {
-	case <-done:
+	case err := <-errCh:
+		return err
 	case <-ctx.Done():
 	}
-	a.db.Close()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err == nil {
+		return nil
+	}
+	log.Printf("Drain timeout of %v exceeded, closing the event streams", a.drainTimeout)
+	if !a.hub.close(a.killTimeout) {
+		log.Printf("Event streams still open after %v", a.killTimeout)
+	}
+	return srv.Close()
+}
+
+// abortWithError replies with the error envelope and stops the handler chain
+func abortWithError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, apiError{Message: message, Code: code})
+}
+
+// deleteNotFound replies to the deletion of a missing resource, 404 or, with
+// -delete-idempotent, 204 as if it was just deleted.
+func (a *App) deleteNotFound(c *gin.Context, code, message string) {
+	if a.deleteIdempotent {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	abortWithError(c, http.StatusNotFound, code, message)
+}
+
+// streamingPaths are the routes answering with long lived streams, they
+// are not limited by the request deadline
+var streamingPaths = map[string]bool{
+	"/api/v1/queue/:id/events": true,
+	"/api/v1/admin/logs":       true,
+}
+
+// requestDeadline sets the -request-timeout deadline on the context of
+// the requests, the handlers pass it to the slow operations.
+func (a *App) requestDeadline(c *gin.Context) {
+	if a.requestTimeout <= 0 || streamingPaths[c.FullPath()] {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), a.requestTimeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// validQueueID rejects the queue scoped requests whose queue id isn't a
+// positive integer before the handlers use it in their queries.
+func validQueueID(c *gin.Context) {
+	if !strings.HasPrefix(c.FullPath(), "/api/v1/queue/:id") {
+		return
+	}
+	if id, err := strconv.ParseInt(c.Param("id"), 10, 64); err != nil || id < 1 {
+		abortWithError(c, http.StatusBadRequest, "invalid_queue_id", "invalid queue id")
+	}
 }
 
 // http handlers
 func (a *App) createQueue(c *gin.Context) {
 	var q Queue
-	if err := c.BindJSON(&q); err != nil {
+	if !a.bindJSON(c, &q) {
+		return
+	}
+	if msg := q.validate(); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+	err := a.insertQueue(&q)
+	if isUniqueViolation(err) {
+		abortWithError(c, http.StatusConflict, "queue_name_taken", "queue name already exists")
 		return
 	}
-	_, err := a.db.NamedExec(`INSERT INTO queue (name) VALUES (:name)`, q)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, q)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.IndentedJSON(http.StatusCreated, q)
 }
 
+// insertQueue creates the queue with the defaults for the missing settings
+func (a *App) insertQueue(q *Queue) error {
+	q.setDefaults()
+	q.CreatedAt = a.now().UTC()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer a.rollback(tx)
+	res, err := tx.NamedExec(`INSERT INTO queue (name, sla_seconds, ticket_prefix, created_at, rate_limit, notify_lead_seconds, capacity, capacity_by, paused,
+		require_confirmation, strategy, start_number, opens_at, closes_at, min_group_size, max_group_size, color, description, required_fields,
+		category, position_band_size)
+		VALUES (:name, :sla_seconds, :ticket_prefix, :created_at, :rate_limit, :notify_lead_seconds, :capacity, :capacity_by, :paused,
+		:require_confirmation, :strategy, :start_number, :opens_at, :closes_at, :min_group_size, :max_group_size, :color, :description,
+		:required_fields, :category, :position_band_size)`, q)
+	if err != nil {
+		return err
+	}
+	if q.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	if err := a.audit(tx, q.ID, 0, "queue_created", gin.H{"name": q.Name}); err != nil {
+		return err
+	}
+	return a.commit(tx)
+}
+
+// createDefaultQueue creates the queue named name when there are no queues
+// yet, so a fresh install is usable right away.
+func (a *App) createDefaultQueue(name string) error {
+	var n int64
+	if err := a.db.Get(&n, "SELECT COUNT(*) FROM queue"); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	q := Queue{Name: name}
+	if err := a.insertQueue(&q); err != nil {
+		return err
+	}
+	log.Printf("Created the default queue %d %s", q.ID, q.Name)
+	return nil
+}
+
+// getAllQueues returns the queues, only the ones of the ?category= if given
 func (a *App) getAllQueues(c *gin.Context) {
 	var queues []Queue
-	err := a.db.Select(&queues, "SELECT * FROM queue ORDER BY id ASC")
+	var err error
+	if category, ok := c.GetQuery("category"); ok {
+		err = a.db.Select(&queues, "SELECT * FROM queue WHERE category=$1 ORDER BY id ASC", category)
+	} else {
+		err = a.db.Select(&queues, "SELECT * FROM queue ORDER BY id ASC")
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, queues)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.IndentedJSON(http.StatusOK, queues)
 }
 
 func (a *App) getSingleQueue(c *gin.Context) {
-	id := c.Param("id")
-	var q Queue
-	err := a.db.Get(&q, "SELECT * FROM queue WHERE id=$1", id)
+	q, err := a.getQueue(c.Param("id"))
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "reservation not found"})
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.IndentedJSON(http.StatusOK, q)
 
 }
 
+// updateQueue renames the queue, a missing name is rejected instead of
+// blanking it.
 func (a *App) updateQueue(c *gin.Context) {
 	id := c.Param("id")
 	var q Queue
-	_, err := a.db.Exec(`UPDATE queue SET name=$1 WHERE id = $2`, q.Name, id)
+	if !a.bindJSON(c, &q) {
+		return
+	}
+	if q.Name == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "name is required",
+			Code:    "invalid_request",
+			Fields:  []string{"name"},
+		})
+		return
+	}
+	tx, err := a.db.Beginx()
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, q)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	res, err := tx.Exec(`UPDATE queue SET name=$1 WHERE id = $2`, q.Name, id)
+	if isUniqueViolation(err) {
+		abortWithError(c, http.StatusConflict, "queue_name_taken", "queue name already exists")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	} else if n == 0 {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	queueID, _ := strconv.ParseInt(id, 10, 64)
+	if err := a.audit(tx, queueID, 0, "queue_updated", gin.H{"name": q.Name}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	err = a.commit(tx)
+	a.queueCache.invalidate(id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
 
+// deleteQueue deletes the queue and, with force=true, the reservations
+// still waiting in it; without force a queue with waiting reservations is
+// not deleted so a live line is not wiped by accident.
 func (a *App) deleteQueue(c *gin.Context) {
 	id := c.Param("id")
-	res, err := a.db.Exec("DELETE FROM queue WHERE id=$1", id)
+	force := c.Query("force") == "true"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, res)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var waiting int64
+	if err := tx.Get(&waiting, "SELECT COUNT(*) FROM reservation WHERE queueid=$1 AND status='waiting'", id); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if waiting > 0 && !force {
+		abortWithError(c, http.StatusConflict, "queue_not_empty", "queue not empty")
+		return
+	}
+	res, err := tx.Exec("DELETE FROM queue WHERE id=$1", id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		a.deleteNotFound(c, "queue_not_found", "queue not found")
+		return
+	}
+	queueID, _ := strconv.ParseInt(id, 10, 64)
+	if err := a.audit(tx, queueID, 0, "queue_deleted", gin.H{"waiting": waiting}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	err = a.commit(tx)
+	a.queueCache.invalidate(id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
 
 func (a *App) createReservation(c *gin.Context) {
+	var r Reservation
+	if !a.bindJSON(c, &r) {
+		return
+	}
+	a.addReservation(c, r)
+}
+
+// joinReservation creates a reservation from the query parameters, for
+// kiosks that can only issue GET requests.
+func (a *App) joinReservation(c *gin.Context) {
+	switch {
+	case a.joinLinkSecret != "" && c.Query("signature") != "":
+		if code, msg := a.verifyJoin(c.Param("id"), c.Query("expires"), c.Query("signature")); code != "" {
+			abortWithError(c, http.StatusUnauthorized, code, msg)
+			return
+		}
+	case a.getJoinToken == "":
+		abortWithError(c, http.StatusNotFound, "not_found", "join by GET is disabled")
+		return
+	case subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(a.getJoinToken)) != 1:
+		abortWithError(c, http.StatusUnauthorized, "unauthorized", "invalid token")
+		return
+	}
+	r := Reservation{
+		Name:      c.Query("name"),
+		Phone:     c.Query("phone"),
+		Email:     c.Query("email"),
+		ReturnURL: c.Query("return_url"),
+		Locale:    c.Query("locale"),
+	}
+	if v := c.Query("groupsize"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "invalid_request", "groupsize must be an integer")
+			return
+		}
+		r.GroupSize = n
+	}
+	if err := binding.Validator.ValidateStruct(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	a.addReservation(c, r)
+}
+
+// addReservation appends the validated reservation r to the queue in the
+// request path and replies with the stored reservation.
+func (a *App) addReservation(c *gin.Context, r Reservation) {
+	if e := a.checkLengths(r.Name, r.Phone); e != nil {
+		abortWithLengthError(c, e)
+		return
+	}
+	var err error
+	if r.Phone, err = a.canonicalPhone(r.Phone); err != nil {
+		abortWithPhoneError(c, err)
+		return
+	}
+	if e := a.checkAttachments(r.Attachments); e != nil {
+		abortWithAttachmentError(c, e)
+		return
+	}
+	if r.ReturnURL != "" {
+		if err := a.checkReturnURL(r.ReturnURL); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+				Message: err.Error(),
+				Code:    "invalid_return_url",
+				Fields:  []string{"return_url"},
+			})
+			return
+		}
+	}
+	if r.Locale != "" {
+		lang, ok := a.i18n.resolve(r.Locale)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+				Message: "unknown locale " + r.Locale,
+				Code:    "invalid_locale",
+				Fields:  []string{"locale"},
+			})
+			return
+		}
+		r.Locale = lang
+	}
+	// without an admin token everybody is staff, as in the admin endpoints
+	if r.Priority && a.adminToken != "" && !a.isStaff(c) {
+		abortWithError(c, http.StatusForbidden, "priority_requires_staff", "only the staff can create priority reservations")
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	id := c.Param("id")
-	var r Reservation
-	if err := c.ShouldBindJSON(&r); err != nil {
-		c.IndentedJSON(http.StatusConflict, r)
-		return
-	}
 	i, err := strconv.Atoi(id)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, r)
+		abortWithError(c, http.StatusBadRequest, "invalid_queue_id", "invalid queue id")
 		return
 	}
 	// obtain queue
 	r.QueueID = int64(i)
-	// get the last position in the queue
-	var pos int64
-	err = a.db.Get(&pos, "SELECT COALESCE(MAX(position), 0) FROM reservation WHERE queueid=$1", id)
+	if retry, err := a.queueLimiter.allow(a.db, r.QueueID, a.now()); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	} else if retry > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		abortWithError(c, http.StatusTooManyRequests, "rate_limited", "too many reservations for this queue, try again later")
+		return
+	}
+	q, err := a.getQueue(id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, err)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if q.Paused {
+		abortWithError(c, http.StatusForbidden, "queue_paused", "the queue is not accepting reservations")
+		return
+	}
+	if !q.openAt(a.now()) {
+		abortWithError(c, http.StatusForbidden, "queue_closed", "the queue is closed")
+		return
+	}
+	if missing := q.missingFields(r); len(missing) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "missing required fields: " + strings.Join(missing, ", "),
+			Code:    "missing_required_fields",
+			Fields:  missing,
+		})
 		return
 	}
-	r.Position = pos + 1
 	// default group size to 1
 	if r.GroupSize == 0 {
 		r.GroupSize = 1
 	}
-	_, err = a.db.NamedExec(`INSERT INTO reservation (name, queueid, position, phone, groupSize) 
-		VALUES (:name, :queueid, :position, :phone, :groupSize)`, r)
+	r.Status = StatusWaiting
+	if msg := q.checkGroupSize(r.GroupSize); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_group_size", msg)
+		return
+	}
+	if r.Phone != "" && a.duplicatePhone != "allow" {
+		var existing Reservation
+		err := a.db.Get(&existing, "SELECT * FROM reservation WHERE queueid=$1 AND phone=$2 AND parentid IS NULL AND status='waiting'", id, r.Phone)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		case a.duplicatePhone == "replace":
+			a.replaceReservation(c, q, existing, r)
+			return
+		case a.duplicatePhone == "merge":
+			a.mergeReservation(c, q, existing, r)
+			return
+		default:
+			abortWithError(c, http.StatusConflict, "phone_already_waiting", "the phone is already waiting in this queue")
+			return
+		}
+	}
+	displace := false
+	if q.Capacity != nil {
+		fits, err := a.fitsCapacity(a.db, q, r.GroupSize)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		switch {
+		case fits:
+		case r.Priority && a.vipBypass == "exceed":
+		case r.Priority && a.vipBypass == "displace":
+			// once the reservation is known to be accepted
+			displace = true
+		default:
+			abortWithError(c, http.StatusForbidden, "queue_full", "queue full")
+			return
+		}
+	}
+	// the displacements, the position, the number and the insert either all
+	// happen or none
+	tx, err := a.db.Beginx()
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, err)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
+	defer a.rollback(tx)
+	err = tx.Get(&r.Number, "SELECT COALESCE(MAX(number), $1 - 1) + 1 FROM reservation WHERE queueid=$2", q.StartNumber, id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if a.singleActive && r.Phone != "" {
+		if waiting, err := waitingElsewhere(tx, r.QueueID, r.Phone); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		} else if waiting {
+			abortWithError(c, http.StatusConflict, "phone_waiting_elsewhere", "the phone is already waiting in another queue")
+			return
+		}
+	}
+	if r.Name == "" && a.anonymousName != nil {
+		var name strings.Builder
+		if err := a.anonymousName.Execute(&name, r); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		r.Name = name.String()
+	}
+	var displaced []int64
+	// counting people it can take more than one party
+	for fits := !displace; !fits; {
+		rid, err := a.displaceParty(tx, id)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if rid == 0 {
+			abortWithError(c, http.StatusForbidden, "queue_full", "queue full")
+			return
+		}
+		displaced = append(displaced, rid)
+		if fits, err = a.fitsCapacity(tx, q, r.GroupSize); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+	// get the last position in the queue
+	var pos int64
+	err = tx.Get(&pos, "SELECT COALESCE(MAX(position), 0) FROM reservation WHERE queueid=$1 AND status='waiting'", id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	r.Position = pos + 1
+	r.CreatedAt = a.now().UTC()
+	if r.ConfirmationCode, err = newConfirmationCode(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if r.ScheduledAt != nil {
+		// stored in UTC so they sort as text
+		scheduled := r.ScheduledAt.UTC()
+		r.ScheduledAt = &scheduled
+	}
+	res, err := tx.NamedExec(`INSERT INTO reservation (name, queueid, position, phone, groupsize, created_at, number, notify_lead_seconds,
+		confirmation_code, scheduled_at, return_url, email, priority, locale, attachments)
+		VALUES (:name, :queueid, :position, :phone, :groupsize, :created_at, :number, :notify_lead_seconds,
+		:confirmation_code, :scheduled_at, :return_url, :email, :priority, :locale, :attachments)`, r)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := tx.Get(&r.Seq, "SELECT seq FROM reservation WHERE id=$1", r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "created", gin.H{"position": r.Position, "groupsize": r.GroupSize}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// appended at the back of the queue
+	err = tx.QueryRowx("SELECT COUNT(*), SUM(groupsize) FROM reservation WHERE queueid=$1 AND status='waiting'", id).Scan(&r.GroupPosition, &r.PersonPosition)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	rs := []Reservation{r}
+	if err := a.decorate(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	rs[0].ConfirmationCode = r.ConfirmationCode
+	rs[0].CheckinToken = a.checkinToken(r)
+	r = rs[0]
+	r.Notification = a.notifyJoined(c.Request.Context(), r)
 
+	for _, rid := range displaced {
+		a.emit(Event{Type: EventDeleted, QueueID: r.QueueID, ReservationID: rid})
+	}
+	a.metrics.inc("cola_reservations_created_total")
+	a.emit(Event{Type: EventCreated, QueueID: r.QueueID})
 	c.IndentedJSON(http.StatusCreated, r)
 }
 
 func (a *App) getAllReservations(c *gin.Context) {
 	id := c.Param("id")
-	var reservations []Reservation
-	err := a.db.Select(&reservations, "SELECT * FROM reservation WHERE queueid=$1", id)
+	status, ok := statusFilter(c)
+	if !ok {
+		return
+	}
+	if status != StatusWaiting {
+		a.getLeftReservations(c, status)
+		return
+	}
+	reservations := []Reservation{}
+	var err error
+	if phone := c.Query("phone"); phone != "" {
+		if canonical, err := a.canonicalPhone(phone); err == nil {
+			phone = canonical
+		}
+		// match the current phone or any phone the reservation had before
+		err = a.db.Select(&reservations, selectReservations+` WHERE
+			phone=$2 OR id IN (SELECT reservationid FROM phone_history WHERE phone=$2) ORDER BY group_position`, id, phone)
+	} else {
+		// in serving order, by position but for the appointments
+		err = a.db.Select(&reservations, selectReservations+" ORDER BY group_position", id)
+	}
+	if err == nil {
+		err = a.decorate(reservations)
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, reservations)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.IndentedJSON(http.StatusOK, reservations)
 
 }
 
+// getSingleReservation returns the reservation, waiting with its positions
+// or, once it left the queue, as it was left with its status.
 func (a *App) getSingleReservation(c *gin.Context) {
 	id := c.Param("id")
 	rsvp := c.Param("rsvp")
 	var r Reservation
-	err := a.db.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+	err := a.db.Get(&r, selectReservations+" WHERE id=$2", id, rsvp)
+	if err == nil {
+		rs := []Reservation{r}
+		err = a.decorate(rs)
+		r = rs[0]
+	} else if err == sql.ErrNoRows {
+		err = a.db.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+		if err == sql.ErrNoRows {
+			abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+			return
+		}
+		if err == nil {
+			r.ConfirmationCode = ""
+			rs := []Reservation{r}
+			err = a.setTickets(rs)
+			r = rs[0]
+		}
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "reservation not found"})
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	c.IndentedJSON(http.StatusOK, r)
@@ -266,21 +1595,278 @@ func (a *App) updateReservation(c *gin.Context) {
 	id := c.Param("id")
 	rsvp := c.Param("rsvp")
 	var r Reservation
-	_, err := a.db.Exec(`UPDATE reservation SET name=$1 WHERE queueid=$2 AND id=$3`, r.Name, id, rsvp)
+	if !a.bindJSON(c, &r) {
+		return
+	}
+	if e := a.checkLengths(r.Name, r.Phone); e != nil {
+		abortWithLengthError(c, e)
+		return
+	}
+	var err error
+	if r.Phone, err = a.canonicalPhone(r.Phone); err != nil {
+		abortWithPhoneError(c, err)
+		return
+	}
+	if e := a.checkAttachments(r.Attachments); e != nil {
+		abortWithAttachmentError(c, e)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var current Reservation
+	err = tx.Get(&current, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "reservation not found"})
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
+	q, err := a.getQueue(id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// only the name, the phone, the group size and the attachments are
+	// updated, they are kept when not given
+	if r.Name == "" {
+		r.Name = current.Name
+	}
+	if r.Phone == "" {
+		r.Phone = current.Phone
+	}
+	if r.GroupSize == 0 {
+		r.GroupSize = current.GroupSize
+	}
+	if r.Attachments == nil {
+		r.Attachments = current.Attachments
+	}
+	if r.GroupSize < 0 {
+		abortWithError(c, http.StatusBadRequest, "invalid_group_size", "groupsize must be positive")
+		return
+	}
+	if msg := q.checkGroupSize(r.GroupSize); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_group_size", msg)
+		return
+	}
+	updated := current
+	updated.Name, updated.Phone, updated.GroupSize = r.Name, r.Phone, r.GroupSize
+	if missing := q.missingFields(updated); len(missing) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "missing required fields: " + strings.Join(missing, ", "),
+			Code:    "missing_required_fields",
+			Fields:  missing,
+		})
+		return
+	}
+	phone := current.Phone
+	if a.singleActive && phone != r.Phone && r.Phone != "" {
+		queueID, _ := strconv.ParseInt(id, 10, 64)
+		if waiting, err := waitingElsewhere(tx, queueID, r.Phone); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		} else if waiting {
+			abortWithError(c, http.StatusConflict, "phone_waiting_elsewhere", "the phone is already waiting in another queue")
+			return
+		}
+	}
+	if phone != r.Phone && r.Phone != "" && a.duplicatePhone != "allow" {
+		var exists bool
+		err := tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM reservation WHERE queueid=$1 AND phone=$2 AND parentid IS NULL AND status='waiting')", id, r.Phone)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		if exists {
+			abortWithError(c, http.StatusConflict, "phone_already_waiting", "the phone is already waiting in this queue")
+			return
+		}
+	}
+	// keep the previous phone so the reservation can still be found by it
+	if phone != r.Phone {
+		_, err = tx.Exec("INSERT INTO phone_history (reservationid, phone, changed_at) VALUES ($1, $2, $3)", rsvp, phone, a.now().UTC())
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		err = a.audit(tx, current.QueueID, current.ID, "phone_changed", gin.H{"previous_phone": phone})
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+	_, err = tx.Exec(`UPDATE reservation SET name=$1, phone=$2, groupsize=$3, attachments=$4 WHERE queueid=$5 AND id=$6`,
+		r.Name, r.Phone, r.GroupSize, r.Attachments, id, rsvp)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emitReservation(EventUpdated, id, rsvp)
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
 
+// deleteReservation removes the reservation, waiting or not, the parties
+// behind a waiting one move up one position so the positions stay contiguous.
 func (a *App) deleteReservation(c *gin.Context) {
 	id := c.Param("id")
 	rsvp := c.Param("rsvp")
-	res, err := a.db.Exec("DELETE FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+	if err == sql.ErrNoRows {
+		a.deleteNotFound(c, "reservation_not_found", "reservation not found")
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, res)
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if r.Status == StatusWaiting {
+		if _, err := tx.Exec("UPDATE reservation SET position = position - 1 WHERE queueid=$1 AND position > $2 AND status='waiting'", id, r.Position); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "deleted", gin.H{"from": r.Status, "position": r.Position}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emitReservation(EventDeleted, id, rsvp)
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
+
+// clearReservations empties the queue of reservations, waiting or not, in
+// one statement, the queue itself stays.
+func (a *App) clearReservations(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := a.getQueue(id); err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	res, err := tx.Exec("DELETE FROM reservation WHERE queueid=$1", id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	queueID, _ := strconv.ParseInt(id, 10, 64)
+	if err := a.audit(tx, queueID, 0, "cleared", gin.H{"deleted": n}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if n > 0 {
+		a.emitReservation(EventDeleted, id, "")
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": n})
+}
+
+// waitingElsewhere returns if the phone has a reservation in a queue other
+// than queueID.
+func waitingElsewhere(q sqlx.Queryer, queueID int64, phone string) (bool, error) {
+	var exists bool
+	err := sqlx.Get(q, &exists, "SELECT EXISTS (SELECT 1 FROM reservation WHERE phone=$1 AND queueid!=$2 AND status='waiting')", phone, queueID)
+	return exists, err
+}
+
+// decorate sets the computed fields of the reservations and hides their
+// confirmation codes
+func (a *App) decorate(rs []Reservation) error {
+	for i := range rs {
+		rs[i].ConfirmationCode = ""
+	}
+	if err := a.setTickets(rs); err != nil {
+		return err
+	}
+	a.setEstimates(rs)
+	a.reportPositions(rs)
+	return nil
+}
+
+// setTickets formats the ticket of the reservations with the prefix of
+// their queue and the zero padded reservation number.
+func (a *App) setTickets(rs []Reservation) error {
+	prefixes := map[int64]string{}
+	for i := range rs {
+		prefix, ok := prefixes[rs[i].QueueID]
+		if !ok {
+			q, err := a.getQueue(strconv.FormatInt(rs[i].QueueID, 10))
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			prefix = q.TicketPrefix
+			prefixes[rs[i].QueueID] = prefix
+		}
+		rs[i].Ticket = fmt.Sprintf("%s%0*d", prefix, a.ticketWidth, rs[i].Number)
+	}
+	return nil
+}
+
+// peekQueue returns the next parties to be served, in the order next serves
+// them, without modifying the queue
+func (a *App) peekQueue(c *gin.Context) {
+	id := c.Param("id")
+	count := 1
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			abortWithError(c, http.StatusBadRequest, "invalid_count", "count must be a positive integer")
+			return
+		}
+		count = n
+	}
+	reservations, err := a.upNext(id, count)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.reportPositions(reservations)
+	c.IndentedJSON(http.StatusOK, reservations)
+}
@@ -3,14 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 )
 
 // This function is used for setup before executing the test functions
@@ -69,3 +74,891 @@ func TestCreateQueue(t *testing.T) {
 	})
 
 }
+
+// newTestApp returns an App backed by a private in-memory database
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	a := NewApp("file:" + t.Name() + "?mode=memory&cache=shared")
+	t.Cleanup(func() { a.db.Close() })
+	return a
+}
+
+// decodeError returns the error envelope of a failed response
+func decodeError(w *httptest.ResponseRecorder) (apiError, bool) {
+	var e apiError
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		return e, false
+	}
+	return e, e.Message != "" && e.Code != ""
+}
+
+func TestCreateReturnsID(t *testing.T) {
+	testApp := newTestApp(t)
+	for i, name := range []string{"first_queue", "second_queue"} {
+		w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"`+name+`"}`)
+		var q Queue
+		if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusCreated || q.ID != int64(i+1) {
+			t.Fatalf("expected queue %s created with id %d, got %d %s", name, i+1, w.Code, w.Body.String())
+		}
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	w := doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || r.ID != 2 {
+		t.Fatalf("expected the reservation created with id 2, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", fmt.Sprintf("/api/v1/queue/2/reservation/%d", r.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the returned id to find the reservation, got %d", w.Code)
+	}
+}
+
+func TestUpdateQueueName(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lunch_line"}`)
+
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/1", `{"name":"dinner_line"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status renaming the queue: %d %s", w.Code, w.Body.String())
+	}
+	w := doRequest(testApp, "GET", "/api/v1/queue/1", "")
+	var q Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Name != "dinner_line" {
+		t.Fatalf("expected the queue renamed to dinner_line, got %s", w.Body.String())
+	}
+
+	for _, body := range []string{`{}`, `{"name":"short"}`, `{"name":`} {
+		if w := doRequest(testApp, "PUT", "/api/v1/queue/1", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/42", `{"name":"dinner_line"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 renaming a missing queue, got %d", w.Code)
+	}
+}
+
+func TestInvalidQueueID(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", "/api/v1/queue/abc/reservation", ""},
+		{"POST", "/api/v1/queue/abc/reservation", `{"name":"customer_1","phone":"111111111"}`},
+		{"GET", "/api/v1/queue/abc", ""},
+		{"GET", "/api/v1/queue/0/reservation/1", ""},
+		{"POST", "/api/v1/queue/-1/next", ""},
+	} {
+		w := doRequest(testApp, req.method, req.path, req.body)
+		if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_queue_id" || e.Message != "invalid queue id" {
+			t.Fatalf("expected 400 for %s %s, got %d %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the numeric id to pass, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDuplicateQueueName(t *testing.T) {
+	testApp := newTestApp(t)
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lunch_line"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lunch_line"}`)
+	if e, ok := decodeError(w); w.Code != http.StatusConflict || !ok || e.Code != "queue_name_taken" || e.Message != "queue name already exists" {
+		t.Fatalf("expected 409 creating the queue again, got %d %s", w.Code, w.Body.String())
+	}
+	// nor by renaming another queue
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_line"}`)
+	for _, method := range []string{"PUT", "PATCH"} {
+		if w := doRequest(testApp, method, "/api/v1/queue/2", `{"name":"lunch_line"}`); w.Code != http.StatusConflict {
+			t.Fatalf("expected 409 renaming with %s to a taken name, got %d %s", method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	testApp := newTestApp(t)
+	w := doRequest(testApp, "GET", "/", "")
+	var info map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || info["service"] != "cola-loca" || info["version"] != version || info["docs"] != "/api/v1/openapi.json" {
+		t.Fatalf("unexpected root response: %d %s", w.Code, w.Body.String())
+	}
+	// the docs are served there
+	w = doRequest(testApp, "GET", info["docs"], "")
+	var doc struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || w.Code != http.StatusOK || doc.OpenAPI == "" {
+		t.Fatalf("unexpected docs: %d %s", w.Code, w.Body.String())
+	}
+	if _, ok := doc.Paths["/api/v1/queue/{id}/reservation/{rsvp}"]["put"]; !ok {
+		t.Fatalf("expected the reservation update in the docs, got %v", doc.Paths)
+	}
+}
+
+func TestDatabaseFailureErrorBody(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.db.Close()
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"GET", "/api/v1/queue", ""},
+		{"GET", "/api/v1/queue/1", ""},
+		{"POST", "/api/v1/queue", `{"name":"my_queue1"}`},
+		{"DELETE", "/api/v1/queue/1", ""},
+		{"GET", "/api/v1/queue/1/reservation", ""},
+		{"GET", "/api/v1/queue/1/reservation/1", ""},
+		{"POST", "/api/v1/queue/1/reservation", `{"name":"my_name123","phone":"123456789"}`},
+		{"DELETE", "/api/v1/queue/1/reservation/1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.url, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			req.Header.Add("Content-Type", "application/json")
+			testHTTPResponse(t, testApp.router, req, func(w *httptest.ResponseRecorder) bool {
+				if w.Code != http.StatusInternalServerError {
+					return false
+				}
+				e, ok := decodeError(w)
+				return ok && e.Code == "internal_error"
+			})
+		})
+	}
+}
+
+func TestNotFoundErrorBody(t *testing.T) {
+	testApp := newTestApp(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/queue/1", nil)
+	testHTTPResponse(t, testApp.router, req, func(w *httptest.ResponseRecorder) bool {
+		e, ok := decodeError(w)
+		return w.Code == http.StatusNotFound && ok && e.Code == "queue_not_found"
+	})
+
+	req = httptest.NewRequest("GET", "/api/v1/queue/1/reservation/1", nil)
+	testHTTPResponse(t, testApp.router, req, func(w *httptest.ResponseRecorder) bool {
+		e, ok := decodeError(w)
+		return w.Code == http.StatusNotFound && ok && e.Code == "reservation_not_found"
+	})
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/999/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusNotFound || e.Code != "queue_not_found" || e.Message != "queue not found" {
+		t.Fatalf("expected 404 joining a missing queue, got %d %s", w.Code, w.Body.String())
+	}
+	var n int
+	if err := testApp.db.Get(&n, "SELECT COUNT(*) FROM reservation"); err != nil || n != 0 {
+		t.Fatalf("expected no reservation for the missing queue, got %d %v", n, err)
+	}
+}
+
+// doRequest sends a JSON request to the app and returns the recorded response
+func doRequest(a *App, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+// doAdminRequest is doRequest with the admin bearer token of the app
+func doAdminRequest(a *App, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.adminToken)
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMigrateOldSchema(t *testing.T) {
+	dbname := t.TempDir() + "/old.db"
+	db, err := sqlx.Connect("sqlite3", dbname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`CREATE TABLE queue (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+	CREATE TABLE reservation (id INTEGER PRIMARY KEY, queueid INTEGER, position INTEGER,
+		name TEXT NOT NULL, phone TEXT NOT NULL UNIQUE, groupsize INTEGER);
+	INSERT INTO queue (name) VALUES ("old_queue");
+	INSERT INTO reservation (queueid, position, name, phone, groupsize) VALUES (1, 1, "old_customer", "123456789", 2);`)
+	db.Close()
+
+	testApp := NewApp(dbname)
+	defer testApp.db.Close()
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || r.Name != "old_customer" || r.CreatedAt.IsZero() || r.Seq != 1 {
+		t.Fatalf("unexpected reservation after migration: %d %s", w.Code, w.Body.String())
+	}
+	// the rebuilt table keeps the phones unique
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"new_customer","phone":"123456789"}`)
+	if w.Code == http.StatusCreated {
+		t.Fatalf("expected a duplicated phone to be rejected after migration")
+	}
+}
+
+func TestOrderStableAcrossRestore(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"restore_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	// a reassigned position ties them, then a restore inserts the rows
+	// back with the ids reversed keeping everything else
+	testApp.db.MustExec("UPDATE reservation SET position=1")
+	testApp.db.MustExec(`CREATE TABLE backup AS SELECT * FROM reservation;
+		DELETE FROM reservation;
+		UPDATE backup SET id = 10 - id;
+		INSERT INTO reservation SELECT * FROM backup;
+		DROP TABLE backup;`)
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 3 {
+		t.Fatalf("unexpected reservations after restore: %s", w.Body.String())
+	}
+	for i, r := range rs {
+		if r.Phone != []string{"111111111", "222222222", "333333333"}[i] || r.Seq != int64(i+1) {
+			t.Fatalf("expected the restore to keep the joining order, got %s", w.Body.String())
+		}
+	}
+
+	// the seq of the last reservation isn't reused once it is gone
+	testApp.db.MustExec("DELETE FROM reservation WHERE seq=3")
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_4","phone":"444444444"}`)
+	var seq int64
+	if err := testApp.db.Get(&seq, "SELECT seq FROM reservation WHERE phone='444444444'"); err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 {
+		t.Fatalf("expected the new reservation to get seq 4, got %d", seq)
+	}
+}
+
+func TestUpdateReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"update_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+
+	w := doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_one","phone":"123123123","groupsize":5}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status updating the reservation: %d %s", w.Code, w.Body.String())
+	}
+	var r Reservation
+	if err := testApp.db.Get(&r, "SELECT * FROM reservation WHERE id=1"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "customer_one" || r.Phone != "123123123" || r.GroupSize != 5 || r.Position != 1 {
+		t.Fatalf("unexpected reservation after the update: %+v", r)
+	}
+	// the group size is kept when not given
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_one","phone":"123123123"}`)
+	if err := testApp.db.Get(&r, "SELECT * FROM reservation WHERE id=1"); err != nil || r.GroupSize != 5 {
+		t.Fatalf("expected the group size to be kept, got %d %v", r.GroupSize, err)
+	}
+	// and the name
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"phone":"123123123"}`)
+	if err := testApp.db.Get(&r, "SELECT * FROM reservation WHERE id=1"); err != nil || r.Name != "customer_one" {
+		t.Fatalf("expected the name to be kept, got %q %v", r.Name, err)
+	}
+	// and the phone, without a phone change recorded
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_one"}`)
+	if err := testApp.db.Get(&r, "SELECT * FROM reservation WHERE id=1"); err != nil || r.Phone != "123123123" {
+		t.Fatalf("expected the phone to be kept, got %q %v", r.Phone, err)
+	}
+	var changes int
+	if err := testApp.db.Get(&changes, "SELECT COUNT(*) FROM phone_history WHERE reservationid=1"); err != nil || changes != 1 {
+		t.Fatalf("expected only the first phone change recorded, got %d %v", changes, err)
+	}
+	if err := testApp.db.Get(&changes, "SELECT COUNT(*) FROM audit WHERE reservationid=1 AND action='phone_changed'"); err != nil || changes != 1 {
+		t.Fatalf("expected only the first phone change audited, got %d %v", changes, err)
+	}
+
+	for body, code := range map[string]int{
+		`{"name":"customer_one","phone":"222222222"}`:                http.StatusConflict,
+		`{"name":"customer_one","phone":"1234"}`:                     http.StatusBadRequest,
+		`{"name":"customer_one","phone":"123123123","groupsize":-1}`: http.StatusBadRequest,
+		`{"name":`: http.StatusBadRequest,
+	} {
+		if w := doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", body); w.Code != code {
+			t.Fatalf("expected %d for %s, got %d %s", code, body, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/42", `{"name":"customer_one","phone":"123123123"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 updating a missing reservation, got %d", w.Code)
+	}
+}
+
+func TestDeleteReservationRenumbers(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"delete_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	if w := doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation/2", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status deleting the reservation: %d %s", w.Code, w.Body.String())
+	}
+	var positions []struct {
+		ID       int64 `json:"id"`
+		Position int64 `json:"position"`
+	}
+	if err := testApp.db.Select(&positions, "SELECT id, position FROM reservation ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 3, 4}
+	if len(positions) != 3 {
+		t.Fatalf("unexpected reservations after the delete: %+v", positions)
+	}
+	for i, p := range positions {
+		if p.ID != want[i] || p.Position != int64(i+1) {
+			t.Fatalf("expected the positions 1,2,3 for the ids %v, got %+v", want, positions)
+		}
+	}
+}
+
+func TestDeleteMissingReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"delete_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation/1", "")
+
+	w := doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation/1", "")
+	if e, _ := decodeError(w); w.Code != http.StatusNotFound || e.Code != "reservation_not_found" {
+		t.Fatalf("expected 404 deleting it again, got %d %s", w.Code, w.Body.String())
+	}
+	testApp.deleteIdempotent = true
+	for _, url := range []string{"/api/v1/queue/1/reservation/1", "/api/v1/queue/1/reservation/42", "/api/v1/queue/42"} {
+		if w := doRequest(testApp, "DELETE", url, ""); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Fatalf("expected 204 deleting the missing %s, got %d %s", url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestReservationsInPositionOrder(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"order_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	for id, position := range map[int]int{1: 3, 2: 1, 3: 4, 4: 2} {
+		testApp.db.MustExec("UPDATE reservation SET position=$1 WHERE id=$2", position, id)
+	}
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{2, 4, 1, 3}
+	if len(rs) != len(want) {
+		t.Fatalf("unexpected reservations %s", w.Body.String())
+	}
+	for i, r := range rs {
+		if r.ID != want[i] || r.Position != int64(i+1) {
+			t.Fatalf("expected the ids %v in position order, got %s", want, w.Body.String())
+		}
+	}
+}
+
+func TestFindReservationByPreviousPhone(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"phone_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+
+	w := doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"333333333"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status updating the phone: %d %s", w.Code, w.Body.String())
+	}
+
+	for _, phone := range []string{"333333333", "111111111"} {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation?phone="+phone, "")
+		var r []Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if len(r) != 1 || r[0].ID != 1 || r[0].Phone != "333333333" {
+			t.Fatalf("expected reservation 1 looking up %s, got %s", phone, w.Body.String())
+		}
+	}
+}
+
+func TestPeekQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"peek_queue"}`)
+
+	// an empty queue is not an error
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/peek", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("expected an empty list, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=3", "")
+	var peeked []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &peeked); err != nil {
+		t.Fatal(err)
+	}
+	if len(peeked) != 3 {
+		t.Fatalf("expected 3 parties, got %s", w.Body.String())
+	}
+	for i, r := range peeked {
+		if r.Position != int64(i+1) {
+			t.Fatalf("expected position %d, got %d", i+1, r.Position)
+		}
+	}
+
+	// peeking doesn't modify the queue
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var all []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 reservations after peeking, got %d", len(all))
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=0", "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid count, got %d", w.Code)
+	}
+}
+
+func TestGroupAndPersonPosition(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"position_queue"}`)
+	for i, size := range []string{"2", "4", "3", "1"} {
+		phone := strings.Repeat(strconv.Itoa(i+1), 9)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`","groupsize":`+size+`}`)
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.GroupPosition != 3 || r.PersonPosition != 9 {
+		t.Fatalf("expected group 3 and person 9, got group %d and person %d", r.GroupPosition, r.PersonPosition)
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var all []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[3].GroupPosition != 4 || all[3].PersonPosition != 10 {
+		t.Fatalf("unexpected positions for the last reservation: %s", w.Body.String())
+	}
+}
+
+func TestJoinByGet(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"kiosk_queue"}`)
+
+	// disabled by default
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/join?name=kiosk_customer&phone=123456789", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected join to be disabled, got %d", w.Code)
+	}
+
+	testApp.getJoinToken = "s3cr3t"
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/join?name=kiosk_customer&phone=123456789&token=wrong", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", w.Code)
+	}
+	// same validation as the POST path
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/join?name=short&phone=123456789&token=s3cr3t", "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 with an invalid name, got %d", w.Code)
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/join?name=kiosk_customer&phone=123456789&groupsize=3&token=s3cr3t", "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 joining by GET, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "kiosk_customer" || r.Phone != "123456789" || r.GroupSize != 3 || r.Position != 1 {
+		t.Fatalf("unexpected reservation created by GET: %s", w.Body.String())
+	}
+}
+
+func TestTicketPrefix(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.ticketWidth = 3
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"station_a","ticket_prefix":"A"}`)
+	for i := 1; i <= 15; i++ {
+		phone := fmt.Sprintf("6000000%02d", i)
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("unexpected status creating reservation: %d %s", w.Code, w.Body.String())
+		}
+		if i == 15 {
+			var r Reservation
+			if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Ticket != "A015" || r.Position != 15 {
+				t.Fatalf("expected ticket A015 at position 15, got %s at %d", r.Ticket, r.Position)
+			}
+		}
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/7", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Ticket != "A007" {
+		t.Fatalf("expected ticket A007, got %s", r.Ticket)
+	}
+
+	w = doRequest(testApp, "POST", "/api/v1/queue", `{"name":"station_b","ticket_prefix":"B-1"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid prefix, got %d", w.Code)
+	}
+}
+
+func TestTicketNumbersUnique(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue","start_number":100}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/2/status", `{"status":"cancelled"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333","groupsize":2}`)
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/3/split", `{"sizes":[1,1]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status splitting: %d %s", w.Code, w.Body.String())
+	}
+
+	// the served and cancelled parties keep their numbers
+	for id, want := range map[int]int64{1: 100, 2: 101, 3: 102, 4: 103} {
+		var number int64
+		if err := testApp.db.Get(&number, "SELECT number FROM reservation WHERE id=$1", id); err != nil || number != want {
+			t.Fatalf("expected reservation %d numbered %d, got %d %v", id, want, number, err)
+		}
+	}
+}
+
+func TestAnonymousTicket(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.getJoinToken = "s3cr3t"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"kiosk_queue","start_number":100}`)
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/join?phone=111111111&token=s3cr3t", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || r.Name != "Ticket 100" {
+		t.Fatalf("expected the anonymous ticket to be named Ticket 100, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	r = Reservation{}
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "Ticket 100" {
+		t.Fatalf("expected the generated name to be stored, got %s", w.Body.String())
+	}
+
+	// named parties keep their name
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	if !strings.Contains(w.Body.String(), `"name": "customer_2"`) {
+		t.Fatalf("expected the name to be kept, got %s", w.Body.String())
+	}
+
+	// without template the name stays empty
+	testApp.anonymousName = nil
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"phone":"333333333"}`)
+	r = Reservation{}
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || r.Name != "" || r.Number != 102 {
+		t.Fatalf("expected an empty name for ticket 102, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDeleteQueueWithWaitingReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"busy_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"empty_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+
+	w := doRequest(testApp, "DELETE", "/api/v1/queue/1", "")
+	e, ok := decodeError(w)
+	if w.Code != http.StatusConflict || !ok || e.Message != "queue not empty" {
+		t.Fatalf("expected 409 deleting a busy queue, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the reservation to be kept, got %d", w.Code)
+	}
+
+	if w := doRequest(testApp, "DELETE", "/api/v1/queue/1?force=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected force to delete the busy queue, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the busy queue to be deleted, got %d", w.Code)
+	}
+
+	if w := doRequest(testApp, "DELETE", "/api/v1/queue/2", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the empty queue to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/2", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the empty queue to be deleted, got %d", w.Code)
+	}
+}
+
+func TestPhoneUniquePerQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"bar_waitlist"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dining_waitlist"}`)
+	body := `{"name":"customer_1","phone":"111111111"}`
+	for _, queue := range []string{"1", "2"} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", body); w.Code != http.StatusCreated {
+			t.Fatalf("expected the phone to join queue %s, got %d %s", queue, w.Code, w.Body.String())
+		}
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+	if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "phone_already_waiting" {
+		t.Fatalf("expected 409 joining the same queue twice, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreatedAt(t *testing.T) {
+	testApp := newTestApp(t)
+	start := time.Now().Add(-time.Second)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	recent := func(created time.Time) bool {
+		return !created.IsZero() && !created.Before(start) && created.Before(time.Now().Add(time.Second))
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1", "")
+	var q Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil || w.Code != http.StatusOK || !recent(q.CreatedAt) {
+		t.Fatalf("expected the queue created just now, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusOK || !recent(r.CreatedAt) {
+		t.Fatalf("expected the reservation created just now, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReservationPositionsContiguous(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for i := 1; i <= 2; i++ {
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000"}`, i, i))
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusCreated || r.Position != int64(i) {
+			t.Fatalf("expected the reservation at position %d, got %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	var positions []int64
+	if err := testApp.db.Select(&positions, "SELECT position FROM reservation WHERE queueid=1 ORDER BY position"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(positions) != "[1 2]" {
+		t.Fatalf("expected the positions with no gap, got %v", positions)
+	}
+}
+
+func TestSingleActiveQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"bar_waitlist"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dining_waitlist"}`)
+	body := `{"name":"customer_1","phone":"111111111"}`
+
+	// allowed by default
+	for _, queue := range []string{"1", "2"} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", body); w.Code != http.StatusCreated {
+			t.Fatalf("expected the phone to wait in several queues, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	testApp.singleActive = true
+	body = `{"name":"customer_2","phone":"222222222"}`
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue/2/reservation", body)
+	if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "phone_waiting_elsewhere" {
+		t.Fatalf("expected 409 joining a second queue, got %d %s", w.Code, w.Body.String())
+	}
+	// nor by changing the phone
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_3","phone":"333333333"}`)
+	w = doRequest(testApp, "PUT", "/api/v1/queue/2/reservation/4", `{"name":"customer_3","phone":"222222222"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 changing to a phone waiting elsewhere, got %d %s", w.Code, w.Body.String())
+	}
+	// free to join once served
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/3/serve", "")
+	if w := doRequest(testApp, "POST", "/api/v1/queue/2/reservation", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 once served, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestShutdownClosesStreams(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.drainTimeout = 100 * time.Millisecond
+	testApp.killTimeout = time.Second
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"shutdown_queue"}`)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- testApp.serveHTTP(ctx, ln)
+	}()
+
+	_, reader := subscribe(t, "http://"+ln.Addr().String()+"/api/v1/queue/1/events")
+	start := time.Now()
+	cancel()
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "event:goodbye\n" {
+		t.Fatalf("expected a goodbye event, got %q: %v", line, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(testApp.drainTimeout + testApp.killTimeout):
+		t.Fatalf("expected the server to stop within the deadlines")
+	}
+	if elapsed := time.Since(start); elapsed < testApp.drainTimeout {
+		t.Fatalf("expected the streams to be drained for %v, closed after %v", testApp.drainTimeout, elapsed)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/healthz"); err == nil {
+		t.Fatalf("expected the server to be closed")
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.drainTimeout = 5 * time.Second
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"drain_queue"}`)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- testApp.serveHTTP(ctx, ln)
+	}()
+
+	// the reservation is in flight, waiting for the lock, when the
+	// shutdown starts
+	testApp.mu.Lock()
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/api/v1/queue/1/reservation", "application/json",
+			strings.NewReader(`{"name":"customer_1","phone":"111111111"}`))
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	testApp.mu.Unlock()
+
+	if code := <-status; code != http.StatusCreated {
+		t.Fatalf("expected the in flight reservation to complete, got %d", code)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(testApp.drainTimeout):
+		t.Fatalf("expected the server to stop once drained")
+	}
+}
+
+func TestTrailingSlashAndCase(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"slash_queue"}`)
+	tests := []struct {
+		name          string
+		trailingSlash bool
+		caseFold      bool
+		method        string
+		path          string
+		code          int
+		location      string
+	}{
+		{"slash redirected", true, false, "GET", "/api/v1/queue/", http.StatusMovedPermanently, "/api/v1/queue"},
+		{"slash keeps the method", true, false, "POST", "/api/v1/queue/1/reservation/", http.StatusTemporaryRedirect, "/api/v1/queue/1/reservation"},
+		{"slash strict", false, false, "GET", "/api/v1/queue/", http.StatusNotFound, ""},
+		{"case sensitive", true, false, "GET", "/api/v1/Queue/1", http.StatusNotFound, ""},
+		{"case insensitive", true, true, "GET", "/api/v1/Queue/1", http.StatusMovedPermanently, "/api/v1/queue/1"},
+		{"case insensitive and slash", true, true, "GET", "/api/v1/QUEUE/", http.StatusMovedPermanently, "/api/v1/queue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testApp.router.RedirectTrailingSlash = tt.trailingSlash
+			testApp.router.RedirectFixedPath = tt.caseFold
+			w := doRequest(testApp, tt.method, tt.path, "")
+			if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+				t.Fatalf("expected %d to %q, got %d to %q", tt.code, tt.location, w.Code, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestClearReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+
+	w := doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"deleted":3}` {
+		t.Fatalf("expected the 3 reservations deleted, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("expected no reservations left, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the queue to stay, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "DELETE", "/api/v1/queue/9/reservation", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing queue, got %d %s", w.Code, w.Body.String())
+	}
+}
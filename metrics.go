@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metrics is a minimal registry of counters and gauges exposed in the
+// Prometheus text format, enough for a handful of values without a client
+// library.
+type metrics struct {
+	mu       sync.Mutex
+	help     map[string]string
+	counters map[string]float64
+	// gauges are computed when scraped, keyed by the labels of the sample
+	gauges map[string]func() map[string]float64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		help:     map[string]string{},
+		counters: map[string]float64{},
+		gauges:   map[string]func() map[string]float64{},
+	}
+	m.help["cola_sla_breaches_total"] = "Number of reservations that waited longer than the queue SLA."
+	m.help["cola_reservations_created_total"] = "Number of reservations created."
+	m.help["cola_http_requests_total"] = "Number of HTTP requests handled."
+	for name := range m.help {
+		m.counters[name] = 0
+	}
+	return m
+}
+
+func (m *metrics) inc(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name]++
+}
+
+func (m *metrics) get(name string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+// gauge registers a gauge whose samples are returned by fn, keyed by their
+// labels, e.g. `queue="1"`, or the empty string for no labels.
+func (m *metrics) gauge(name, help string, fn func() map[string]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.help[name] = help
+	m.gauges[name] = fn
+}
+
+// sample is a value of a metric with its labels
+type sample struct {
+	name   string
+	labels string
+	value  float64
+}
+
+// family groups the samples of a metric for the exporters
+type family struct {
+	name    string
+	help    string
+	counter bool
+	samples []sample
+}
+
+// snapshot returns the current values of all the metrics sorted by name and
+// labels.
+func (m *metrics) snapshot() []family {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.counters)+len(m.gauges))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	for name := range m.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, 0, len(names))
+	for _, name := range names {
+		f := family{name: name, help: m.help[name]}
+		fn, ok := m.gauges[name]
+		if !ok {
+			f.counter = true
+			f.samples = []sample{{name: name, value: m.counters[name]}}
+			families = append(families, f)
+			continue
+		}
+		samples := fn()
+		labels := make([]string, 0, len(samples))
+		for l := range samples {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			f.samples = append(f.samples, sample{name: name, labels: l, value: samples[l]})
+		}
+		families = append(families, f)
+	}
+	return families
+}
+
+// queueLabels selects the queues the per queue metrics are labeled with, so
+// the number of series doesn't grow with the queues, the rest are aggregated.
+type queueLabels struct {
+	all     bool
+	allowed map[int64]bool
+}
+
+// parseQueueLabels parses -metrics-queue-labels, empty aggregates all the
+// queues, all labels every queue and a comma separated list of queue ids
+// labels only those.
+func parseQueueLabels(s string) (queueLabels, error) {
+	s = strings.TrimSpace(s)
+	if s == "all" {
+		return queueLabels{all: true}, nil
+	}
+	l := queueLabels{allowed: map[int64]bool{}}
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return l, fmt.Errorf("invalid queue id %q", id)
+		}
+		l.allowed[n] = true
+	}
+	return l, nil
+}
+
+// samples returns the samples of the per queue values, the queues not
+// labeled are summed in queue="other", or in the unlabeled sample if no
+// queue is labeled.
+func (l queueLabels) samples(perQueue map[int64]int) map[string]float64 {
+	samples := map[string]float64{}
+	if !l.all && len(l.allowed) == 0 {
+		var total float64
+		for _, n := range perQueue {
+			total += float64(n)
+		}
+		samples[""] = total
+		return samples
+	}
+	for q, n := range perQueue {
+		label := `queue="other"`
+		if l.all || l.allowed[q] {
+			label = fmt.Sprintf("queue=%q", strconv.FormatInt(q, 10))
+		}
+		samples[label] += float64(n)
+	}
+	return samples
+}
+
+func (m *metrics) handler(c *gin.Context) {
+	var b strings.Builder
+	for _, f := range m.snapshot() {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		if f.counter {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", f.name)
+		} else {
+			fmt.Fprintf(&b, "# TYPE %s gauge\n", f.name)
+		}
+		for _, s := range f.samples {
+			if s.labels == "" {
+				fmt.Fprintf(&b, "%s %g\n", s.name, s.value)
+			} else {
+				fmt.Fprintf(&b, "%s{%s} %g\n", s.name, s.labels, s.value)
+			}
+		}
+	}
+	c.Data(200, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// countRequests is the middleware counting the HTTP requests
+func (a *App) countRequests(c *gin.Context) {
+	a.metrics.inc("cola_http_requests_total")
+	c.Next()
+}
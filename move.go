@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// MoveRequest places a reservation right after or right before another
+// reservation of the same queue, only one of them can be set
+type MoveRequest struct {
+	After  *int64 `json:"after"`
+	Before *int64 `json:"before"`
+}
+
+// PositionRequest places a reservation at the position, in the configured
+// base, moving the parties in between
+type PositionRequest struct {
+	Position *int64 `json:"position" binding:"required"`
+}
+
+// slot is a waiting reservation of the queue being reordered
+type slot struct {
+	ID       int64 `json:"id"`
+	QueueID  int64 `json:"queueid"`
+	Position int64 `json:"position"`
+}
+
+// waitingOrder returns the waiting reservations of the queue in serving order
+func waitingOrder(tx *sqlx.Tx, queueID string) ([]slot, error) {
+	var slots []slot
+	err := tx.Select(&slots, "SELECT id, queueid, position FROM reservation WHERE queueid=$1 AND status='waiting' ORDER BY "+servingOrder, queueID)
+	return slots, err
+}
+
+// indexOf returns the index of the reservation in the slots, -1 if missing
+func indexOf(slots []slot, rsvp int64) int {
+	for i, s := range slots {
+		if s.ID == rsvp {
+			return i
+		}
+	}
+	return -1
+}
+
+// placeAt moves the reservation at index from to index to, the parties in
+// between shift by one, and renumbers the queue to a contiguous 1..N
+// sequence auditing the moves.
+func (a *App) placeAt(tx *sqlx.Tx, slots []slot, from, to int) error {
+	moved := slots[from]
+	slots = append(slots[:from:from], slots[from+1:]...)
+	slots = append(slots[:to:to], append([]slot{moved}, slots[to:]...)...)
+	for i, r := range slots {
+		pos := int64(i + 1)
+		if r.Position == pos {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE reservation SET position=$1 WHERE id=$2", pos, r.ID); err != nil {
+			return err
+		}
+		if err := a.audit(tx, r.QueueID, r.ID, "moved", gin.H{"from": r.Position, "to": pos}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replyMoved commits the reordering and replies with the moved reservation
+func (a *App) replyMoved(c *gin.Context, tx *sqlx.Tx, id string, rsvp int64) {
+	var r Reservation
+	if err := tx.Get(&r, selectReservations+" WHERE id=$2", id, rsvp); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	rs := []Reservation{r}
+	if err := a.decorate(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventUpdated, QueueID: r.QueueID, ReservationID: r.ID})
+	c.IndentedJSON(http.StatusOK, rs[0])
+}
+
+// moveReservation repositions the reservation next to another one of the
+// queue and renumbers the waiting reservations to a contiguous sequence. In
+// the queues in appointment mode the appointments keep their scheduled order.
+func (a *App) moveReservation(c *gin.Context) {
+	id := c.Param("id")
+	rsvp, err := strconv.ParseInt(c.Param("rsvp"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_reservation_id", "invalid reservation id")
+		return
+	}
+	var req MoveRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+	if (req.After == nil) == (req.Before == nil) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "one of after or before is required",
+			Code:    "invalid_request",
+			Fields:  []string{"after", "before"},
+		})
+		return
+	}
+	other, after := req.Before, false
+	if req.After != nil {
+		other, after = req.After, true
+	}
+	if *other == rsvp {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", "a reservation can't be moved next to itself")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	slots, err := waitingOrder(tx, id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	moving, target := indexOf(slots, rsvp), indexOf(slots, *other)
+	if moving < 0 {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if target < 0 {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation "+strconv.FormatInt(*other, 10)+" not found in the queue")
+		return
+	}
+	// the index of the other one once the moving one is taken out
+	if target > moving {
+		target--
+	}
+	if after {
+		target++
+	}
+	if err := a.placeAt(tx, slots, moving, target); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.replyMoved(c, tx, id, rsvp)
+}
+
+// setReservationPosition moves the reservation to the position, shifting the
+// parties in between so the positions stay contiguous.
+func (a *App) setReservationPosition(c *gin.Context) {
+	id := c.Param("id")
+	rsvp, err := strconv.ParseInt(c.Param("rsvp"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_reservation_id", "invalid reservation id")
+		return
+	}
+	var req PositionRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	slots, err := waitingOrder(tx, id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	moving := indexOf(slots, rsvp)
+	if moving < 0 {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	// stored 1-based as the positions
+	position := *req.Position - a.positionBase + 1
+	if position < 1 || position > int64(len(slots)) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "position must be between " + strconv.FormatInt(a.positionBase, 10) + " and " +
+				strconv.FormatInt(a.reportPosition(int64(len(slots))), 10),
+			Code:   "invalid_position",
+			Fields: []string{"position"},
+		})
+		return
+	}
+	if err := a.placeAt(tx, slots, moving, int(position-1)); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.replyMoved(c, tx, id, rsvp)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestMoveReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"move_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"other_queue"}`)
+	for _, body := range []string{
+		`{"name":"customer_1","phone":"111111111"}`,
+		`{"name":"customer_2","phone":"222222222"}`,
+		`{"name":"customer_3","phone":"333333333"}`,
+		`{"name":"customer_4","phone":"444444444"}`,
+	} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_5","phone":"555555555"}`)
+	order := func() []int64 {
+		t.Helper()
+		var rs []Reservation
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for i, r := range rs {
+			if r.Position != int64(i+1) {
+				t.Fatalf("expected contiguous positions, got %s", w.Body.String())
+			}
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	expectOrder := func(expected ...int64) {
+		t.Helper()
+		ids := order()
+		if len(ids) != len(expected) {
+			t.Fatalf("expected order %v, got %v", expected, ids)
+		}
+		for i := range ids {
+			if ids[i] != expected[i] {
+				t.Fatalf("expected order %v, got %v", expected, ids)
+			}
+		}
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/move", `{"after":3}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || r.ID != 1 || r.Position != 3 {
+		t.Fatalf("expected the reservation moved to position 3, got %d %s", w.Code, w.Body.String())
+	}
+	expectOrder(2, 3, 1, 4)
+
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/4/move", `{"before":2}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 moving to the front, got %d %s", w.Code, w.Body.String())
+	}
+	expectOrder(4, 2, 3, 1)
+
+	// moving to the back
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/4/move", `{"after":1}`)
+	expectOrder(2, 3, 1, 4)
+
+	for _, tc := range []struct {
+		url, body string
+		status    int
+	}{
+		{"/api/v1/queue/1/reservation/1/move", `{}`, http.StatusBadRequest},
+		{"/api/v1/queue/1/reservation/1/move", `{"after":2,"before":3}`, http.StatusBadRequest},
+		{"/api/v1/queue/1/reservation/1/move", `{"after":1}`, http.StatusBadRequest},
+		{"/api/v1/queue/1/reservation/1/move", `{"after":5}`, http.StatusNotFound},
+		{"/api/v1/queue/1/reservation/5/move", `{"after":1}`, http.StatusNotFound},
+		{"/api/v1/queue/1/reservation/9/move", `{"after":1}`, http.StatusNotFound},
+	} {
+		if w := doRequest(testApp, "POST", tc.url, tc.body); w.Code != tc.status {
+			t.Fatalf("expected %d moving %s %s, got %d %s", tc.status, tc.url, tc.body, w.Code, w.Body.String())
+		}
+	}
+	expectOrder(2, 3, 1, 4)
+}
+
+func TestSetReservationPosition(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"position_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444", "555555555"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	positions := func() map[int64]int64 {
+		t.Helper()
+		var rs []Reservation
+		if err := testApp.db.Select(&rs, "SELECT * FROM reservation"); err != nil {
+			t.Fatal(err)
+		}
+		positions := map[int64]int64{}
+		for _, r := range rs {
+			positions[r.ID] = r.Position
+		}
+		return positions
+	}
+
+	w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/4/position", `{"position":1}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || r.ID != 4 || r.Position != 1 {
+		t.Fatalf("expected the reservation moved to the front, got %d %s", w.Code, w.Body.String())
+	}
+	// the ones it jumped over shifted down by one, the last one stays
+	for id, expected := range map[int64]int64{1: 2, 2: 3, 3: 4, 4: 1, 5: 5} {
+		if p := positions()[id]; p != expected {
+			t.Fatalf("expected reservation %d at %d, got %v", id, expected, positions())
+		}
+	}
+
+	// and back, shifting up
+	doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/4/position", `{"position":5}`)
+	for id, expected := range map[int64]int64{1: 1, 2: 2, 3: 3, 5: 4, 4: 5} {
+		if p := positions()[id]; p != expected {
+			t.Fatalf("expected reservation %d at %d, got %v", id, expected, positions())
+		}
+	}
+
+	for body, code := range map[string]int{
+		`{"position":0}`: http.StatusBadRequest,
+		`{"position":6}`: http.StatusBadRequest,
+		`{}`:             http.StatusBadRequest,
+	} {
+		if w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/4/position", body); w.Code != code {
+			t.Fatalf("expected %d for %s, got %d %s", code, body, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/42/position", `{"position":1}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 moving a missing reservation, got %d", w.Code)
+	}
+}
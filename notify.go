@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// notification kinds
+const (
+	NotificationReadySoon = "ready_soon"
+	NotificationJoined    = "joined"
+)
+
+// notificationBuffer is how many notifications wait to be delivered before
+// queueing one more waits for the delivery of the others
+const notificationBuffer = 100
+
+// Notification is a message for the party of a reservation
+type Notification struct {
+	Kind          string `json:"kind"`
+	QueueID       int64  `json:"queueid"`
+	ReservationID int64  `json:"reservationid"`
+	Name          string `json:"name"`
+	Phone         string `json:"phone"`
+	// Locale is the language of the message
+	Locale  string    `json:"locale"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// notifier delivers the notifications, e.g. by SMS
+type notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// eventNotifier emits the notifications as events, so they reach the
+// webhook and the event streams of the queue.
+type eventNotifier struct {
+	app *App
+}
+
+func (e *eventNotifier) Notify(ctx context.Context, n Notification) error {
+	log.Printf("Notifying reservation %d of queue %d: %s", n.ReservationID, n.QueueID, n.Message)
+	e.app.emit(Event{Type: EventNotification, QueueID: n.QueueID, ReservationID: n.ReservationID, Time: n.Time})
+	return nil
+}
+
+// checkNotifications notifies, only once, the parties whose estimated wait
+// dropped to the notification lead time of the reservation or its queue.
+func (a *App) checkNotifications() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var queues []struct {
+		ID                int64 `json:"id"`
+		NotifyLeadSeconds int64 `json:"notify_lead_seconds"`
+	}
+	if err := a.db.Select(&queues, "SELECT id, notify_lead_seconds FROM queue"); err != nil {
+		return err
+	}
+	now := a.now().UTC()
+	for _, q := range queues {
+		var rs []Reservation
+		err := a.db.Select(&rs, selectReservations+" WHERE notified_at IS NULL", q.ID)
+		if err != nil {
+			return err
+		}
+		a.setEstimates(rs)
+		for _, r := range rs {
+			lead := q.NotifyLeadSeconds
+			if r.NotifyLeadSeconds != nil {
+				lead = *r.NotifyLeadSeconds
+			}
+			if lead <= 0 || r.EstimatedWaitSeconds > lead {
+				continue
+			}
+			lang := a.i18n.fallback
+			if r.Locale != "" {
+				lang = r.Locale
+			}
+			n := Notification{
+				Kind:          NotificationReadySoon,
+				QueueID:       r.QueueID,
+				ReservationID: r.ID,
+				Name:          r.Name,
+				Phone:         r.Phone,
+				Locale:        lang,
+				Message: a.i18n.message(lang, "notification.ready_soon", map[string]int64{
+					"Minutes": r.EstimatedWaitSeconds / 60,
+				}),
+				Time: now,
+			}
+			if err := a.notifier.Notify(context.Background(), n); err != nil {
+				log.Printf("Error notifying reservation %d: %v", r.ID, err)
+				continue
+			}
+			if err := a.markNotified(r, n.Kind, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markNotified records the reservation was notified, so it isn't again
+func (a *App) markNotified(r Reservation, kind string, now time.Time) error {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer a.rollback(tx)
+	if _, err := tx.Exec("UPDATE reservation SET notified_at=$1 WHERE id=$2", now, r.ID); err != nil {
+		return err
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "notified", map[string]string{"kind": kind}); err != nil {
+		return err
+	}
+	return a.commit(tx)
+}
+
+// notifyJoined queues the notification of a new reservation to its party,
+// waiting for room in the queue until the context is done. It returns
+// queued, or skipped when the party has no phone or the context is done.
+func (a *App) notifyJoined(ctx context.Context, r Reservation) string {
+	if r.Phone == "" {
+		return "skipped"
+	}
+	lang := a.i18n.fallback
+	if r.Locale != "" {
+		lang = r.Locale
+	}
+	n := Notification{
+		Kind:          NotificationJoined,
+		QueueID:       r.QueueID,
+		ReservationID: r.ID,
+		Name:          r.Name,
+		Phone:         r.Phone,
+		Locale:        lang,
+		Message:       a.i18n.message(lang, "notification.joined", r),
+		Time:          a.now().UTC(),
+	}
+	select {
+	case a.notifications <- n:
+		return "queued"
+	case <-ctx.Done():
+		log.Printf("Skipping the notification of reservation %d: %v", r.ID, ctx.Err())
+		return "skipped"
+	}
+}
+
+// deliverNotifications delivers the queued notifications until the context
+// is done, one at a time so a slow notifier doesn't pile up deliveries.
+func (a *App) deliverNotifications(ctx context.Context) {
+	for {
+		select {
+		case n := <-a.notifications:
+			if err := a.notifier.Notify(ctx, n); err != nil {
+				log.Printf("Error notifying reservation %d: %v", n.ReservationID, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
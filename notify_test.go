@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications instead of delivering them
+type fakeNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notifications = append(f.notifications, n)
+	return nil
+}
+
+func (f *fakeNotifier) sent() []Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Notification(nil), f.notifications...)
+}
+
+func TestNotifyLeadTime(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.avgWait = 5 * time.Minute
+	fake := &fakeNotifier{}
+	testApp.notifier = fake
+
+	// 10 minutes is two parties ahead
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"notify_queue","notify_lead_seconds":600}`)
+	for i := 1; i <= 4; i++ {
+		phone := strconv.Itoa(111111111 * i)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+	// the last one opted out of the notifications
+	testApp.db.MustExec("UPDATE reservation SET notify_lead_seconds=0 WHERE id=4")
+
+	if err := testApp.checkNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	sent := fake.sent()
+	if len(sent) != 3 || sent[0].ReservationID != 1 || sent[2].ReservationID != 3 {
+		t.Fatalf("expected the first three reservations to be notified, got %+v", sent)
+	}
+	if sent[2].Message != "Your turn is coming, about 10 minutes left." {
+		t.Fatalf("unexpected message %q", sent[2].Message)
+	}
+
+	// a fourth party crossing the threshold after the queue advances
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_5","phone":"555555555"}`)
+	if err := testApp.checkNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.sent()) != 3 {
+		t.Fatalf("expected no new notifications before the queue advances, got %+v", fake.sent())
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve", "")
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/2/serve", "")
+	for i := 0; i < 2; i++ {
+		if err := testApp.checkNotifications(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent = fake.sent()
+	if len(sent) != 4 || sent[3].ReservationID != 5 {
+		t.Fatalf("expected reservation 5 to be notified once, got %+v", sent)
+	}
+}
+
+func TestNotificationLocale(t *testing.T) {
+	testApp := newTestApp(t)
+	fake := &fakeNotifier{}
+	testApp.notifier = fake
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"locale_queue","notify_lead_seconds":600}`)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","locale":"es-ES"}`); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status creating the reservation: %d %s", w.Code, w.Body.String())
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333","locale":"xx"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_locale" {
+		t.Fatalf("expected an unknown locale to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	if err := testApp.checkNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	sent := fake.sent()
+	if len(sent) != 2 {
+		t.Fatalf("expected both reservations to be notified, got %+v", sent)
+	}
+	if sent[0].Locale != "es" || sent[0].Message != "Se acerca tu turno, quedan unos 0 minutos." {
+		t.Fatalf("expected the first party notified in Spanish, got %+v", sent[0])
+	}
+	if sent[1].Locale != "en" || !strings.HasPrefix(sent[1].Message, "Your turn is coming") {
+		t.Fatalf("expected the second party notified in the default language, got %+v", sent[1])
+	}
+
+	// the status follows the locale of the party without an Accept-Language
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+	if w.Header().Get("Content-Language") != "es" {
+		t.Fatalf("expected the status in Spanish, got %q", w.Header().Get("Content-Language"))
+	}
+}
+
+// slowNotifier takes its time delivering each notification
+type slowNotifier struct {
+	fakeNotifier
+	delay time.Duration
+}
+
+func (s *slowNotifier) Notify(ctx context.Context, n Notification) error {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.fakeNotifier.Notify(ctx, n)
+}
+
+func TestNotifyJoinedDeadline(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.requestTimeout = 100 * time.Millisecond
+	slow := &slowNotifier{delay: time.Second}
+	testApp.notifier = slow
+	testApp.notifications = make(chan Notification, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go testApp.deliverNotifications(ctx)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"notify_queue","required_fields":["name"]}`)
+	join := func(body string) (Reservation, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body)
+		elapsed := time.Since(start)
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("unexpected reservation: %d %s", w.Code, w.Body.String())
+		}
+		return r, elapsed
+	}
+
+	// the delivery is slow, not the request, one being delivered and one queued
+	for _, phone := range []string{"111111111", "222222222"} {
+		if r, elapsed := join(`{"name":"customer_` + phone + `","phone":"` + phone + `"}`); r.Notification != "queued" || elapsed > 500*time.Millisecond {
+			t.Fatalf("expected the notification queued right away, got %q after %v", r.Notification, elapsed)
+		}
+	}
+	if r, _ := join(`{"name":"customer_3"}`); r.Notification != "skipped" {
+		t.Fatalf("expected the notification without phone skipped, got %q", r.Notification)
+	}
+
+	// no room until the deadline
+	if r, elapsed := join(`{"name":"customer_4","phone":"444444444"}`); r.Notification != "skipped" || elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the notification skipped at the deadline, got %q after %v", r.Notification, elapsed)
+	}
+	if n := len(slow.sent()); n > 1 {
+		t.Fatalf("expected the slow deliveries still going, got %d sent", n)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mutatingReads are the GET routes that change the queues, the observers
+// can't use them either
+var mutatingReads = map[string]bool{
+	"/api/v1/queue/:id/join": true,
+}
+
+// isObserver returns if the request carries the observer bearer token,
+// without an observer token set nobody is.
+func (a *App) isObserver(c *gin.Context) bool {
+	if a.observerToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.observerToken)) == 1
+}
+
+// observerAccess keeps the observers read only, any request changing
+// something is forbidden, and masks the phones of the JSON they read.
+func (a *App) observerAccess(c *gin.Context) {
+	if !a.isObserver(c) {
+		return
+	}
+	if (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) || mutatingReads[c.FullPath()] {
+		abortWithError(c, http.StatusForbidden, "read_only", "observers can not change anything")
+		return
+	}
+	if streamingPaths[c.FullPath()] {
+		return
+	}
+	w := &maskingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	w.flush()
+}
+
+// maskingWriter holds back the JSON responses to mask the phones in them,
+// the other responses go through untouched.
+type maskingWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *maskingWriter) isJSON() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *maskingWriter) Write(b []byte) (int, error) {
+	if !w.isJSON() {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+func (w *maskingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// flush writes the JSON held back with its phones masked
+func (w *maskingWriter) flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	var v interface{}
+	body := w.buf.Bytes()
+	if err := json.Unmarshal(body, &v); err == nil {
+		if masked, err := json.MarshalIndent(maskPhones(v), "", "    "); err == nil {
+			body = masked
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// phoneFields are the JSON fields holding a phone, the reservations and the
+// audit details of the timelines
+var phoneFields = map[string]bool{
+	"phone":          true,
+	"previous_phone": true,
+}
+
+// maskPhones masks the phone fields of a decoded JSON value
+func maskPhones(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && phoneFields[k] {
+				v[k] = maskPhone(s)
+			} else {
+				v[k] = maskPhones(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = maskPhones(e)
+		}
+	}
+	return v
+}
+
+// maskPhone hides all but the last 3 characters of a phone
+func maskPhone(phone string) string {
+	r := []rune(phone)
+	for i := 0; i < len(r)-3; i++ {
+		r[i] = '*'
+	}
+	return string(r)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObserverReadOnly(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.observerToken = "l00k1ng"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	observe := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer l00k1ng")
+		w := httptest.NewRecorder()
+		testApp.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := observe("GET", "/api/v1/queue/1/reservation")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil || w.Code != http.StatusOK || len(rs) != 1 {
+		t.Fatalf("expected the observer to list the reservations, got %d %s", w.Code, w.Body.String())
+	}
+	if rs[0].Phone != "******111" || rs[0].Name != "customer_1" {
+		t.Fatalf("expected the phone masked, got %+v", rs[0])
+	}
+	if w := observe("GET", "/api/v1/queue/1/stats"); w.Code != http.StatusOK {
+		t.Fatalf("expected the observer to read the stats, got %d %s", w.Code, w.Body.String())
+	}
+	w = observe("GET", "/api/v1/queue/1/export?format=csv")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), ",******111,") {
+		t.Fatalf("expected the phones masked in the export, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, req := range [][2]string{
+		{"DELETE", "/api/v1/queue/1/reservation/1"},
+		{"POST", "/api/v1/queue/1/next"},
+		{"PUT", "/api/v1/queue/1"},
+		{"GET", "/api/v1/queue/1/join?name=customer_2&phone=222222222"},
+	} {
+		w := observe(req[0], req[1])
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "read_only" {
+			t.Fatalf("expected 403 for %s %s, got %d %s", req[0], req[1], w.Code, w.Body.String())
+		}
+	}
+
+	// nor the phones of the timelines
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"111222333"}`)
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"111111111"}`)
+	w = observe("GET", "/api/v1/queue/1/reservation/1/timeline")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"previous_phone": "******333"`) || strings.Contains(w.Body.String(), "111222333") {
+		t.Fatalf("expected the previous phones masked in the timeline, got %d %s", w.Code, w.Body.String())
+	}
+
+	// the others still see the phones and can change the queue
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || r.Phone != "111111111" {
+		t.Fatalf("expected the phone unmasked, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "DELETE", "/api/v1/queue/1/reservation/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the reservation deleted, got %d %s", w.Code, w.Body.String())
+	}
+}
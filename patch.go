@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueuePatch holds the queue settings of a partial update, with the same
+// validations as the Queue ones.
+type QueuePatch struct {
+	Name              *string `json:"name" binding:"omitempty,min=8"`
+	SLASeconds        *int64  `json:"sla_seconds" binding:"omitempty,min=0"`
+	TicketPrefix      *string `json:"ticket_prefix" binding:"omitempty,max=4,alphanum"`
+	RateLimit         *int64  `json:"rate_limit" binding:"omitempty,min=0"`
+	NotifyLeadSeconds *int64  `json:"notify_lead_seconds" binding:"omitempty,min=0"`
+	Capacity          *int64  `json:"capacity" binding:"omitempty,min=1"`
+	CapacityBy        *string `json:"capacity_by" binding:"omitempty,oneof=parties people"`
+	Paused            *bool   `json:"paused"`
+	// RequireConfirmation affects the reservations already waiting
+	RequireConfirmation *bool      `json:"require_confirmation"`
+	Strategy            *string    `json:"strategy" binding:"omitempty,oneof=fifo appointment"`
+	StartNumber         *int64     `json:"start_number" binding:"omitempty,min=1"`
+	OpensAt             *string    `json:"opens_at" binding:"omitempty,datetime=15:04"`
+	ClosesAt            *string    `json:"closes_at" binding:"omitempty,datetime=15:04"`
+	MinGroupSize        *int64     `json:"min_group_size" binding:"omitempty,min=0"`
+	MaxGroupSize        *int64     `json:"max_group_size" binding:"omitempty,min=0"`
+	Color               *string    `json:"color" binding:"omitempty,hexcolor"`
+	Description         *string    `json:"description" binding:"omitempty,max=200"`
+	Category            *string    `json:"category" binding:"omitempty,max=50"`
+	PositionBandSize    *int64     `json:"position_band_size" binding:"omitempty,min=0"`
+	RequiredFields      *fieldList `json:"required_fields" binding:"omitempty,dive,oneof=name phone groupsize email"`
+}
+
+// nullableQueueColumns can be cleared sending null
+var nullableQueueColumns = map[string]bool{
+	"capacity": true,
+}
+
+// patchQueue updates only the settings present in the body and returns the
+// updated queue.
+func (a *App) patchQueue(c *gin.Context) {
+	id := c.Param("id")
+	body, err := c.GetRawData()
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(body, &present); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var p QueuePatch
+	if !a.bindJSON(c, &p) {
+		return
+	}
+
+	var set []string
+	var args []interface{}
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		column := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := present[column]; !ok {
+			continue
+		}
+		f := v.Field(i)
+		if f.IsNil() && !nullableQueueColumns[column] {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+				Message: column + " can not be null",
+				Code:    "invalid_request",
+				Fields:  []string{column},
+			})
+			return
+		}
+		set = append(set, column+"=?")
+		args = append(args, f.Interface())
+	}
+
+	// unpausing reopens a closed queue
+	if p.Paused != nil && !*p.Paused {
+		set = append(set, "closed_at=NULL")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	if len(set) > 0 {
+		args = append(args, id)
+		_, err := tx.Exec("UPDATE queue SET "+strings.Join(set, ", ")+" WHERE id=?", args...)
+		if isUniqueViolation(err) {
+			abortWithError(c, http.StatusConflict, "queue_name_taken", "queue name already exists")
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+	var q Queue
+	err = tx.Get(&q, "SELECT * FROM queue WHERE id=$1", id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// the settings depending on each other are checked once merged
+	if msg := q.validate(); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+	if err := a.audit(tx, q.ID, 0, "queue_updated", present); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.queueCache.invalidate(id)
+	c.IndentedJSON(http.StatusOK, q)
+}
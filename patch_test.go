@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPatchQueue(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"patch_queue","sla_seconds":600,"ticket_prefix":"A","rate_limit":10}`)
+
+	patch := func(body string) (Queue, int) {
+		w := doRequest(testApp, "PATCH", "/api/v1/queue/1", body)
+		var q Queue
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return q, w.Code
+	}
+	preserved := func(q Queue) {
+		t.Helper()
+		if q.Name != "patch_queue" || q.SLASeconds != 600 || q.TicketPrefix != "A" || q.RateLimit != 10 {
+			t.Fatalf("expected the other settings to be preserved, got %+v", q)
+		}
+	}
+
+	q, code := patch(`{"capacity":2}`)
+	if code != http.StatusOK || q.Capacity == nil || *q.Capacity != 2 || q.Paused {
+		t.Fatalf("expected capacity 2, got %d %+v", code, q)
+	}
+	preserved(q)
+
+	q, code = patch(`{"paused":true}`)
+	if code != http.StatusOK || !q.Paused || q.Capacity == nil || *q.Capacity != 2 {
+		t.Fatalf("expected a paused queue keeping its capacity, got %d %+v", code, q)
+	}
+	preserved(q)
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_paused" {
+		t.Fatalf("expected a paused queue to reject reservations, got %d %s", w.Code, w.Body.String())
+	}
+
+	q, code = patch(`{"paused":false,"capacity":null}`)
+	if code != http.StatusOK || q.Paused || q.Capacity != nil {
+		t.Fatalf("expected an unlimited resumed queue, got %d %+v", code, q)
+	}
+	preserved(q)
+
+	if _, code := patch(`{"capacity":0}`); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid capacity to be rejected, got %d", code)
+	}
+	if _, code := patch(`{"sla_seconds":null}`); code != http.StatusBadRequest {
+		t.Fatalf("expected a null sla to be rejected, got %d", code)
+	}
+	if w := doRequest(testApp, "PATCH", "/api/v1/queue/2", `{"paused":true}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown queue, got %d", w.Code)
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"capacity_queue","capacity":2}`)
+	for _, phone := range []string{"111111111", "222222222"} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+		t.Fatalf("expected a full queue to reject the reservation, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestQueueCapacityByPeople(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"capacity_queue","capacity":4,"capacity_by":"people"}`)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":3}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":2}`)
+	if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+		t.Fatalf("expected the party not fitting to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333","groupsize":1}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the party fitting to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"other_queue","capacity_by":"tables"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown capacity_by to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+}
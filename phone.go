@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// e164MaxDigits is the most digits an E.164 number has, the country code
+// included
+const e164MaxDigits = 15
+
+var (
+	errPhoneCharacters = errors.New("phone can only have digits, spaces, dashes, dots, parentheses and a leading +")
+	errPhoneDigits     = errors.New("phone is not a valid E.164 number")
+)
+
+// canonicalPhone returns the phone in the form it is stored and looked up
+// with: the digits with a leading + and the country code, E.164. The
+// numbers typed without country code get countryCode, without it they are
+// kept national, just the digits. It fails on the phones that can't be a
+// number at all, an empty phone stays empty.
+func canonicalPhone(phone, countryCode string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", nil
+	}
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9', r == ' ', r == '-', r == '.', r == '(', r == ')':
+		case r == '+' && i == 0:
+		default:
+			return "", errPhoneCharacters
+		}
+	}
+	digits := strings.TrimPrefix(normalizePhone(phone), "+")
+	international := strings.HasPrefix(phone, "+")
+	if !international && strings.HasPrefix(digits, "00") {
+		digits, international = digits[2:], true
+	}
+	if !international && countryCode != "" {
+		digits, international = countryCode+digits, true
+	}
+	if digits == "" || len(digits) > e164MaxDigits || (international && digits[0] == '0') {
+		return "", errPhoneDigits
+	}
+	if international {
+		return "+" + digits, nil
+	}
+	return digits, nil
+}
+
+// canonicalPhone returns the phone with -phone-country-code
+func (a *App) canonicalPhone(phone string) (string, error) {
+	return canonicalPhone(phone, a.phoneCountryCode)
+}
+
+// phoneMatches returns if the phone looked up is the stored one, the stored
+// phones from before they were canonical are compared the same way.
+func (a *App) phoneMatches(stored, lookup string) bool {
+	s, err := a.canonicalPhone(stored)
+	if err != nil {
+		s = normalizePhone(stored)
+	}
+	// an unescaped + in the query string reads as a space, so the lookup
+	// also matches with it
+	l := strings.TrimPrefix(normalizePhone(lookup), "+")
+	if l == "" {
+		return false
+	}
+	if c, err := a.canonicalPhone(l); err == nil && c == s {
+		return true
+	}
+	return strings.TrimPrefix(s, "+") == l
+}
+
+// abortWithPhoneError replies 400 with why the phone is invalid
+func abortWithPhoneError(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+		Message: err.Error(),
+		Code:    "invalid_phone",
+		Fields:  []string{"phone"},
+	})
+}
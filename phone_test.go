@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCanonicalPhone(t *testing.T) {
+	for _, tt := range []struct {
+		phone, countryCode, want string
+		err                      error
+	}{
+		{"", "34", "", nil},
+		{"123 456 789", "", "123456789", nil},
+		{"123 456 789", "34", "+34123456789", nil},
+		{"+34 123-456-789", "34", "+34123456789", nil},
+		{"0034 (123) 456.789", "", "+34123456789", nil},
+		{"+1 555 123 4567", "34", "+15551234567", nil},
+		{"123456789x", "34", "", errPhoneCharacters},
+		{"12+3456789", "", "", errPhoneCharacters},
+		{"+0123456789", "", "", errPhoneDigits},
+		{"+1234567890123456", "", "", errPhoneDigits},
+		{"- - -", "", "", errPhoneDigits},
+	} {
+		got, err := canonicalPhone(tt.phone, tt.countryCode)
+		if got != tt.want || err != tt.err {
+			t.Errorf("canonicalPhone(%q, %q) = %q, %v, expected %q, %v", tt.phone, tt.countryCode, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPhoneNormalized(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.phoneCountryCode = "34"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"123 456 789"}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusCreated || r.Phone != "+34123456789" {
+		t.Fatalf("expected the phone stored in E.164, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"+34 123-456-789"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "phone_already_waiting" {
+		t.Fatalf("expected the same number typed differently to collide, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"123-CALL-NOW"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_phone" || len(e.Fields) != 1 {
+		t.Fatalf("expected 400 for an invalid phone, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/1", `{"name":"customer_1","phone":"+0 123 456 789"}`)
+	if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_phone" {
+		t.Fatalf("expected 400 updating to an invalid phone, got %d %s", w.Code, w.Body.String())
+	}
+
+	// looked up in any of its forms
+	for _, phone := range []string{"123456789", "%2B34123456789", "+34123456789", "0034123456789"} {
+		if w := doRequest(testApp, "GET", "/api/v1/queue/1/position?phone="+phone, ""); w.Code != http.StatusOK {
+			t.Fatalf("expected the reservation found by %s, got %d %s", phone, w.Code, w.Body.String())
+		}
+	}
+	var rs []Reservation
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation?phone=123%20456%20789", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil || len(rs) != 1 {
+		t.Fatalf("expected the reservation listed by its phone, got %d %s", w.Code, w.Body.String())
+	}
+}
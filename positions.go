@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PositionSample is the position of a reservation at some point in time
+type PositionSample struct {
+	Time     time.Time `json:"time"`
+	Position int64     `json:"position"`
+}
+
+// samplePositions records the position of the waiting reservations whose
+// position changed since their last sample, so the history only grows when
+// the parties move.
+func (a *App) samplePositions() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := a.db.Exec(`INSERT INTO position_sample (reservationid, position, sampled_at)
+		SELECT id, group_position, $1 FROM (SELECT id,
+			ROW_NUMBER() OVER (PARTITION BY queueid ORDER BY `+servingOrder+`) AS group_position
+			FROM reservation WHERE status='waiting') p
+		WHERE group_position IS NOT (SELECT position FROM position_sample s
+			WHERE s.reservationid = p.id ORDER BY s.id DESC LIMIT 1)`, a.now().UTC())
+	return err
+}
+
+// getPositionHistory returns the sampled positions of a waiting reservation,
+// oldest first, once it leaves the queue its samples are kept but no longer
+// served.
+func (a *App) getPositionHistory(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	var waiting bool
+	err := a.db.Get(&waiting, "SELECT EXISTS (SELECT 1 FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting')", id, rsvp)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !waiting {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	var rows []struct {
+		Position  int64     `json:"position"`
+		SampledAt time.Time `json:"sampled_at"`
+	}
+	err = a.db.Select(&rows, "SELECT position, sampled_at FROM position_sample WHERE reservationid=$1 ORDER BY id ASC", rsvp)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	history := make([]PositionSample, len(rows))
+	for i, r := range rows {
+		history[i] = PositionSample{Time: r.SampledAt, Position: a.reportPosition(r.Position)}
+	}
+	c.IndentedJSON(http.StatusOK, history)
+}
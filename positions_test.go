@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPositionHistory(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"history_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+
+	sample := func() {
+		t.Helper()
+		if err := testApp.samplePositions(); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+	}
+	sample()
+	for i := 0; i < 2; i++ {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/next", ""); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status serving the next party: %d %s", w.Code, w.Body.String())
+		}
+		sample()
+	}
+	// nobody moved, nothing is recorded
+	sample()
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/4/position-history", "")
+	var history []PositionSample
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(history) != 3 {
+		t.Fatalf("expected 3 samples, got %d %s", w.Code, w.Body.String())
+	}
+	start := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	for i, s := range history {
+		if s.Position != int64(4-i) || !s.Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Fatalf("expected decreasing positions every minute, got %s", w.Body.String())
+		}
+	}
+
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/position-history", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a served reservation, got %d", w.Code)
+	}
+}
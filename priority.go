@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// fitsCapacity returns if a party of size fits in the queue capacity, the
+// queue counts the parties waiting or, with capacity_by people, their
+// people.
+func (a *App) fitsCapacity(db sqlx.Queryer, q Queue, size int64) (bool, error) {
+	if q.Capacity == nil {
+		return true, nil
+	}
+	query := "SELECT COUNT(*) FROM reservation WHERE queueid=$1 AND status='waiting'"
+	if q.CapacityBy == "people" {
+		query = "SELECT COALESCE(SUM(groupsize), 0) FROM reservation WHERE queueid=$1 AND status='waiting'"
+	} else {
+		size = 1
+	}
+	var waiting int64
+	if err := sqlx.Get(db, &waiting, query, q.ID); err != nil {
+		return false, err
+	}
+	return waiting+size <= *q.Capacity, nil
+}
+
+// displaceParty removes the party without priority served last to make room
+// for a priority reservation in a full queue, it returns the id of the
+// displaced reservation, 0 if all the parties waiting have priority. It runs
+// in the transaction of the reservation, so nobody is displaced for a
+// reservation rejected after all.
+func (a *App) displaceParty(tx *sqlx.Tx, queueID string) (int64, error) {
+	var r Reservation
+	err := tx.Get(&r, selectReservations+" WHERE NOT priority ORDER BY group_position DESC LIMIT 1", queueID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE reservation SET status=$1 WHERE id=$2", StatusCancelled, r.ID); err != nil {
+		return 0, err
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "displaced", gin.H{"from": "waiting", "position": r.Position}); err != nil {
+		return 0, err
+	}
+	if _, err := a.resequence(tx, queueID); err != nil {
+		return 0, err
+	}
+	return r.ID, nil
+}
+
+// effectivePriority is the priority of the reservation aged by the time it
+// waited, a priority reservation starts at 1 and the others at 0.
+func (a *App) effectivePriority(r Reservation, now time.Time) float64 {
+	p := now.Sub(r.CreatedAt).Hours() * a.priorityAging
+	if r.Priority {
+		p++
+	}
+	return p
+}
+
+// agedOrder orders the parties, in serving order, the way they are called
+// with priority aging: the first priority party unless the first one without
+// priority aged past it. The parties of each kind keep their order.
+func (a *App) agedOrder(rs []Reservation, now time.Time, count int) []Reservation {
+	var priority, others []Reservation
+	for _, r := range rs {
+		if r.Priority {
+			priority = append(priority, r)
+		} else {
+			others = append(others, r)
+		}
+	}
+	order := []Reservation{}
+	for len(order) < count && len(priority)+len(others) > 0 {
+		switch {
+		case len(others) == 0:
+			order, priority = append(order, priority[0]), priority[1:]
+		case len(priority) == 0:
+			order, others = append(order, others[0]), others[1:]
+		default:
+			p, o := a.effectivePriority(priority[0], now), a.effectivePriority(others[0], now)
+			if o > p || (o == p && others[0].Position < priority[0].Position) {
+				order, others = append(order, others[0]), others[1:]
+			} else {
+				order, priority = append(order, priority[0]), priority[1:]
+			}
+		}
+	}
+	return order
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapacityVIPBypass(t *testing.T) {
+	fill := func(t *testing.T, bypass string) *App {
+		testApp := newTestApp(t)
+		testApp.vipBypass = bypass
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"vip_queue","capacity":2}`)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+		return testApp
+	}
+	waiting := func(t *testing.T, testApp *App) []string {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+		var rs []Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+			t.Fatal(err)
+		}
+		phones := []string{}
+		for _, r := range rs {
+			phones = append(phones, r.Phone)
+		}
+		return phones
+	}
+	const vip = `{"name":"customer_v","phone":"999999999","priority":true}`
+
+	t.Run("off", func(t *testing.T) {
+		testApp := fill(t, "off")
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", vip)
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+			t.Fatalf("expected the VIP to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("exceed", func(t *testing.T) {
+		testApp := fill(t, "exceed")
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+			t.Fatalf("expected a normal party to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", vip); w.Code != http.StatusCreated {
+			t.Fatalf("expected the VIP to exceed the capacity, got %d %s", w.Code, w.Body.String())
+		}
+		if phones := waiting(t, testApp); strings.Join(phones, ",") != "111111111,222222222,999999999" {
+			t.Fatalf("unexpected waiting parties %v", phones)
+		}
+	})
+
+	t.Run("displace", func(t *testing.T) {
+		testApp := fill(t, "displace")
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", vip); w.Code != http.StatusCreated {
+			t.Fatalf("expected the VIP to displace a party, got %d %s", w.Code, w.Body.String())
+		}
+		if phones := waiting(t, testApp); strings.Join(phones, ",") != "111111111,999999999" {
+			t.Fatalf("expected the last party to be displaced, got %v", phones)
+		}
+		var displaced int
+		if err := testApp.db.Get(&displaced, "SELECT COUNT(*) FROM audit WHERE action='displaced'"); err != nil || displaced != 1 {
+			t.Fatalf("expected the displacement to be audited, got %d %v", displaced, err)
+		}
+		// the next VIP displaces the other party, then only VIPs are left
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_w","phone":"888888888","priority":true}`)
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_x","phone":"777777777","priority":true}`)
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "queue_full" {
+			t.Fatalf("expected a full queue of VIPs to reject, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("displace rejected", func(t *testing.T) {
+		testApp := fill(t, "displace")
+		testApp.singleActive = true
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lunch_queue"}`)
+		doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_v","phone":"999999999"}`)
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", vip)
+		if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "phone_waiting_elsewhere" {
+			t.Fatalf("expected the VIP waiting elsewhere to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+		if phones := waiting(t, testApp); strings.Join(phones, ",") != "111111111,222222222" {
+			t.Fatalf("expected nobody displaced for a rejected VIP, got %v", phones)
+		}
+		var displaced int
+		if err := testApp.db.Get(&displaced, "SELECT COUNT(*) FROM audit WHERE action='displaced'"); err != nil || displaced != 0 {
+			t.Fatalf("expected no displacement audited, got %d %v", displaced, err)
+		}
+	})
+
+	t.Run("staff", func(t *testing.T) {
+		testApp := fill(t, "exceed")
+		testApp.adminToken = "s3cr3t"
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", vip)
+		if e, _ := decodeError(w); w.Code != http.StatusForbidden || e.Code != "priority_requires_staff" {
+			t.Fatalf("expected priority to require the staff, got %d %s", w.Code, w.Body.String())
+		}
+		req, _ := http.NewRequest("POST", "/api/v1/queue/1/reservation", strings.NewReader(vip))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		w = httptest.NewRecorder()
+		testApp.router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected the staff to create a VIP, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestPriorityAging(t *testing.T) {
+	testApp := newTestApp(t)
+	// a party waiting 30m out-ranks a fresh priority one
+	testApp.priorityAging = 2
+	now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"aging_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	next := func() int64 {
+		t.Helper()
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+		var s ServedReservation
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected next party: %d %s", w.Code, w.Body.String())
+		}
+		return s.ReservationID
+	}
+
+	// not aged enough yet
+	now = now.Add(20 * time.Minute)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_v","phone":"999999999","priority":true}`)
+	if id := next(); id != 2 {
+		t.Fatalf("expected the priority party first, served %d", id)
+	}
+
+	// past the aging threshold
+	now = now.Add(20 * time.Minute)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_w","phone":"888888888","priority":true}`)
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=2", "")
+	var peeked []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &peeked); err != nil || len(peeked) != 2 || peeked[0].ID != 1 || peeked[1].ID != 3 {
+		t.Fatalf("expected to peek the aged party first, got %d %s", w.Code, w.Body.String())
+	}
+	if id := next(); id != 1 {
+		t.Fatalf("expected the aged party first, served %d", id)
+	}
+	if id := next(); id != 3 {
+		t.Fatalf("expected the priority party next, served %d", id)
+	}
+
+	// disabled, in position order
+	testApp.priorityAging = 0
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_v","phone":"999999999","priority":true}`)
+	if id := next(); id != 4 {
+		t.Fatalf("expected the first party in line, served %d", id)
+	}
+}
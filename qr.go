@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrMaxVersion is the largest version encoded, 57x57 modules, so the codes
+// stay large enough to scan once printed
+const qrMaxVersion = 10
+
+// qrQuietZone is the white border around the code, in modules
+const qrQuietZone = 4
+
+// encodeQR returns the QR code of the data at the medium error correction
+// level, ok is false if it needs a version larger than qrMaxVersion.
+func encodeQR(data string) (q *qrcode.QRCode, ok bool) {
+	q, err := qrcode.New(data, qrcode.Medium)
+	if err != nil || q.VersionNumber > qrMaxVersion {
+		return nil, false
+	}
+	return q, true
+}
+
+// renderQR returns the code as a PNG at most size pixels wide, the modules
+// are square and at least one pixel.
+func renderQR(q *qrcode.QRCode, size int) ([]byte, error) {
+	width := q.VersionNumber*4 + 17 + 2*qrQuietZone
+	scale := size / width
+	if scale < 1 {
+		scale = 1
+	}
+	// a negative size is the pixels per module
+	return q.PNG(-scale)
+}
+
+// getReservationQR returns a PNG QR code with the status URL of the
+// waiting reservation, for the printed tickets, ?size= is the width in
+// pixels.
+func (a *App) getReservationQR(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	size := qrDefaultSize
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			abortWithError(c, http.StatusBadRequest, "invalid_size", fmt.Sprintf("size must be between %d and %d pixels", qrMinSize, qrMaxSize))
+			return
+		}
+		size = n
+	}
+	var waiting bool
+	err := a.db.Get(&waiting, "SELECT EXISTS (SELECT 1 FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting')", id, rsvp)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !waiting {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	u := url.URL{
+		Scheme: requestScheme(c),
+		Host:   c.Request.Host,
+		Path:   "/api/v1/queue/" + id + "/reservation/" + rsvp + "/status",
+	}
+	q, ok := encodeQR(u.String())
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, "url_too_long", "the status URL is too long for a QR code")
+		return
+	}
+	img, err := renderQR(q, size)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "image/png", img)
+}
+
+// the width of the QR codes, in pixels, by default and allowed
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 2048
+)
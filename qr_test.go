@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// readQR decodes the QR code of the image with a decoder independent of the
+// encoder
+func readQR(t *testing.T, img image.Image) string {
+	t.Helper()
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := zxingqr.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("expected a readable QR code: %v", err)
+	}
+	return res.GetText()
+}
+
+func TestReservationQR(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+
+	for _, size := range []int{0, 100, 512} {
+		path := "/api/v1/queue/1/reservation/1/qr"
+		if size > 0 {
+			path += fmt.Sprintf("?size=%d", size)
+		}
+		w := doRequest(testApp, "GET", path, "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("expected a PNG, got %d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size == 0 {
+			size = qrDefaultSize
+		}
+		if b := img.Bounds(); b.Dx() != b.Dy() || b.Dx() > size || b.Dx() < size/2 {
+			t.Fatalf("expected a square of at most %d pixels, got %v", size, b)
+		}
+		if got, want := readQR(t, img), "http://example.com/api/v1/queue/1/reservation/1/status"; got != want {
+			t.Fatalf("expected the code to encode %s, got %s", want, got)
+		}
+	}
+
+	// up to the largest version, 213 bytes at the medium level
+	long := strings.Repeat("a", 213)
+	q, ok := encodeQR(long)
+	if !ok || q.VersionNumber != qrMaxVersion {
+		t.Fatalf("expected the longest data in the largest version")
+	}
+	b, err := renderQR(q, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil || readQR(t, img) != long {
+		t.Fatalf("expected the longest data to read back: %v", err)
+	}
+	if _, ok := encodeQR(long + "a"); ok {
+		t.Fatalf("expected the data to be too long")
+	}
+
+	for path, code := range map[string]int{
+		"/api/v1/queue/1/reservation/1/qr?size=10":   http.StatusBadRequest,
+		"/api/v1/queue/1/reservation/1/qr?size=big":  http.StatusBadRequest,
+		"/api/v1/queue/1/reservation/9/qr":           http.StatusNotFound,
+		"/api/v1/queue/1/reservation/1/qr?size=4096": http.StatusBadRequest,
+	} {
+		if w := doRequest(testApp, "GET", path, ""); w.Code != code {
+			t.Fatalf("expected %d for %s, got %d %s", code, path, w.Code, w.Body.String())
+		}
+	}
+}
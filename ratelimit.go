@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// bucket is a token bucket refilled at rate tokens per minute, up to rate
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// queueLimiter keeps a token bucket per queue with a rate limit configured
+type queueLimiter struct {
+	mu      sync.Mutex
+	buckets map[int64]*bucket
+}
+
+func newQueueLimiter() *queueLimiter {
+	return &queueLimiter{buckets: map[int64]*bucket{}}
+}
+
+// allow takes a token from the bucket of the queue, it returns how long to
+// wait for the next token if the bucket is empty.
+func (l *queueLimiter) allow(db *sqlx.DB, queueID int64, now time.Time) (time.Duration, error) {
+	var limit int64
+	err := db.Get(&limit, "SELECT COALESCE((SELECT rate_limit FROM queue WHERE id=$1), 0)", queueID)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit <= 0 {
+		delete(l.buckets, queueID)
+		return 0, nil
+	}
+	rate := float64(limit)
+	b, ok := l.buckets[queueID]
+	if !ok {
+		b = &bucket{tokens: rate, last: now}
+		l.buckets[queueID] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Minute)), nil
+	}
+	b.tokens--
+	return 0, nil
+}
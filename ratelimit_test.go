@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQueueRateLimit(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"limited_queue","rate_limit":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"unlimited_queue"}`)
+
+	phone := 100000000
+	join := func(queue int) int {
+		phone++
+		body := fmt.Sprintf(`{"name":"customer_%d","phone":"%d"}`, phone, phone)
+		w := doRequest(testApp, "POST", fmt.Sprintf("/api/v1/queue/%d/reservation", queue), body)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "30" {
+			t.Fatalf("expected Retry-After 30, got %q", w.Header().Get("Retry-After"))
+		}
+		return w.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := join(1); code != http.StatusCreated {
+			t.Fatalf("expected reservation %d to be accepted, got %d", i, code)
+		}
+	}
+	if code := join(1); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the limited queue to throttle, got %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := join(2); code != http.StatusCreated {
+			t.Fatalf("expected the other queue to be unaffected, got %d", code)
+		}
+	}
+
+	// a token every 30 seconds
+	now = now.Add(30 * time.Second)
+	if code := join(1); code != http.StatusCreated {
+		t.Fatalf("expected the limited queue to accept after the refill, got %d", code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/unix"
+)
+
+// databaseDir returns the directory of the database file, or empty for the
+// memory databases.
+func databaseDir(dbname string) string {
+	if dbname == "" || dbname == ":memory:" || strings.Contains(dbname, "mode=memory") || strings.HasPrefix(dbname, "file::memory:") {
+		return ""
+	}
+	path := strings.TrimPrefix(dbname, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	return filepath.Dir(path)
+}
+
+// freeDiskSpace returns the bytes available to unprivileged users in the
+// filesystem of dir.
+func freeDiskSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// healthz reports if the app is alive, which it isn't without a database
+// answering the queries.
+func (a *App) healthz(c *gin.Context) {
+	var one int
+	if err := a.db.QueryRowContext(c.Request.Context(), "SELECT 1").Scan(&one); err != nil {
+		abortWithError(c, http.StatusServiceUnavailable, "database_unavailable", err.Error())
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
+
+// readyz reports if the app can take requests: the database answers and,
+// with a threshold, its filesystem has enough free space for the writes.
+func (a *App) readyz(c *gin.Context) {
+	if err := a.db.PingContext(c.Request.Context()); err != nil {
+		abortWithError(c, http.StatusServiceUnavailable, "database_unavailable", err.Error())
+		return
+	}
+	if a.minFreeDisk > 0 && a.dbDir != "" {
+		free, err := a.freeDiskSpace(a.dbDir)
+		if err != nil {
+			abortWithError(c, http.StatusServiceUnavailable, "disk_check_failed", err.Error())
+			return
+		}
+		if free < a.minFreeDisk {
+			abortWithError(c, http.StatusServiceUnavailable, "low_disk_space",
+				fmt.Sprintf("%d bytes free in %s, below the %d bytes required", free, a.dbDir, a.minFreeDisk))
+			return
+		}
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestReadyzDiskSpace(t *testing.T) {
+	testApp := newTestApp(t)
+	if testApp.dbDir != "" {
+		t.Fatalf("expected no database directory in memory, got %s", testApp.dbDir)
+	}
+	testApp.dbDir = t.TempDir()
+	testApp.minFreeDisk = 100 << 20
+	free, err := uint64(1<<30), error(nil)
+	testApp.freeDiskSpace = func(dir string) (uint64, error) {
+		if dir != testApp.dbDir {
+			t.Fatalf("unexpected directory checked %s", dir)
+		}
+		return free, err
+	}
+
+	if w := doRequest(testApp, "GET", "/readyz", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected ready with enough space, got %d %s", w.Code, w.Body.String())
+	}
+	free = 10 << 20
+	w := doRequest(testApp, "GET", "/readyz", "")
+	if e, _ := decodeError(w); w.Code != http.StatusServiceUnavailable || e.Code != "low_disk_space" {
+		t.Fatalf("expected 503 with low disk space, got %d %s", w.Code, w.Body.String())
+	}
+	free, err = 0, errors.New("statfs failed")
+	if w := doRequest(testApp, "GET", "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the check fails, got %d %s", w.Code, w.Body.String())
+	}
+	// disabled
+	testApp.minFreeDisk = 0
+	if w := doRequest(testApp, "GET", "/readyz", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected ready with the check disabled, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	free, err := freeDiskSpace(t.TempDir())
+	if err != nil || free == 0 {
+		t.Fatalf("expected the free space of the temporary directory, got %d %v", free, err)
+	}
+	if dir := databaseDir("file:/var/lib/cola/cola.db?_busy_timeout=500"); dir != "/var/lib/cola" {
+		t.Fatalf("unexpected database directory %s", dir)
+	}
+}
+
+func TestHealthzDatabase(t *testing.T) {
+	testApp := newTestApp(t)
+	if w := doRequest(testApp, "GET", "/healthz", ""); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("expected healthy, got %d %s", w.Code, w.Body.String())
+	}
+	testApp.db.Close()
+	w := doRequest(testApp, "GET", "/healthz", "")
+	if e, _ := decodeError(w); w.Code != http.StatusServiceUnavailable || e.Code != "database_unavailable" {
+		t.Fatalf("expected 503 with the database closed, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// checkReturnURL validates that the return URL is absolute, http or https,
+// and points to one of the allowed hosts.
+func (a *App) checkReturnURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("return_url must be an absolute URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("return_url must be an http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("return_url can not have credentials")
+	}
+	if !a.returnURLHosts[strings.ToLower(u.Hostname())] {
+		return errors.New("return_url host " + u.Hostname() + " is not allowed")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReturnURL(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.returnURLHosts = map[string]bool{"kiosk.example.com": true}
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"web_queue"}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","return_url":"https://Kiosk.example.com:8443/done?x=1"}`)
+	var r Reservation
+	json.Unmarshal(w.Body.Bytes(), &r)
+	if w.Code != http.StatusCreated || r.ReturnURL != "https://Kiosk.example.com:8443/done?x=1" {
+		t.Fatalf("expected the allowed return URL to be echoed, got %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1", "")
+	r = Reservation{}
+	json.Unmarshal(w.Body.Bytes(), &r)
+	if r.ReturnURL != "https://Kiosk.example.com:8443/done?x=1" {
+		t.Fatalf("expected the return URL to be stored, got %s", w.Body.String())
+	}
+
+	for _, u := range []string{
+		"https://evil.example.com/done",
+		"https://kiosk.example.com.evil.com/",
+		"https://user@kiosk.example.com/",
+		"javascript://kiosk.example.com/",
+		"/relative/path",
+	} {
+		body, _ := json.Marshal(Reservation{Name: "customer_2", Phone: "222222222", ReturnURL: u})
+		w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", string(body))
+		if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_return_url" {
+			t.Fatalf("expected %s to be rejected, got %d %s", u, w.Code, w.Body.String())
+		}
+	}
+}
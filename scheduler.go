@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// every runs fn each interval until the context is done, a non positive
+// interval disables it.
+func (a *App) every(ctx context.Context, name string, interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := fn(); err != nil {
+				log.Printf("Error running %s: %v", name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// likeEscaper escapes the LIKE wildcards, the queries use \ as escape
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// normalizePhone keeps only the digits and the leading +, so the phones
+// match regardless of the separators typed.
+func normalizePhone(s string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(s) {
+		if unicode.IsDigit(r) || (i == 0 && r == '+') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// searchReservations matches q against the name and the phone of the
+// reservations of the queue, ranking the exact phone matches first, then the
+// names starting with q and last the names or phones containing it.
+func (a *App) searchReservations(c *gin.Context) {
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if q == "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_query", "q is required")
+		return
+	}
+	name := likeEscaper.Replace(q)
+	phone := normalizePhone(q)
+	reservations := []Reservation{}
+	err := a.db.Select(&reservations, selectReservations+` WHERE
+		($2 != '' AND phone LIKE '%' || $3 || '%' ESCAPE '\') OR LOWER(TRIM(name)) LIKE '%' || $4 || '%' ESCAPE '\'
+		ORDER BY CASE
+			WHEN $2 != '' AND phone=$2 THEN 0
+			WHEN LOWER(TRIM(name)) LIKE $4 || '%' ESCAPE '\' THEN 1
+			ELSE 2
+		END, group_position`, c.Param("id"), phone, likeEscaper.Replace(phone), name)
+	if err == nil {
+		err = a.decorate(reservations)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, reservations)
+}
+
+// PhonePosition is where the reservation of a phone is in the queue, the
+// queues reporting bands of positions return PositionBand instead
+type PhonePosition struct {
+	ID           int64  `json:"id"`
+	Ticket       string `json:"ticket"`
+	Position     int64  `json:"position,omitempty"`
+	PositionBand string `json:"position_band,omitempty"`
+	PartiesAhead int64  `json:"parties_ahead"`
+}
+
+// getPositionByPhone returns the position of the first reservation of the
+// queue, in serving order, with the ?phone=.
+func (a *App) getPositionByPhone(c *gin.Context) {
+	id := c.Param("id")
+	phone := c.Query("phone")
+	if normalizePhone(phone) == "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", "phone is required")
+		return
+	}
+	q, err := a.getQueue(id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var rs []Reservation
+	if err := a.db.Select(&rs, selectReservations+" ORDER BY group_position", id); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, r := range rs {
+		if !a.phoneMatches(r.Phone, phone) {
+			continue
+		}
+		found := []Reservation{r}
+		if err := a.decorate(found); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		p := PhonePosition{ID: r.ID, Ticket: found[0].Ticket, PartiesAhead: r.GroupPosition - 1}
+		if q.PositionBandSize > 0 && !a.isStaff(c) {
+			p.PositionBand = a.positionBand(r.GroupPosition, q.PositionBandSize)
+		} else {
+			p.Position = found[0].GroupPosition
+		}
+		c.IndentedJSON(http.StatusOK, p)
+		return
+	}
+	abortWithError(c, http.StatusNotFound, "reservation_not_found", "no reservation with that phone in the queue")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSearchReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"search_queue"}`)
+	for _, body := range []string{
+		`{"name":"Maria 600123456 Lopez","phone":"555000111"}`,
+		`{"name":"600123456 Garcia","phone":"555000222"}`,
+		`{"name":"Someone Else","phone":"600123456"}`,
+		`{"name":"Unrelated Party","phone":"555000333"}`,
+		`{"name":"Percent 100% off","phone":"555000444"}`,
+	} {
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", body); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	search := func(q string) []string {
+		t.Helper()
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/search?q="+url.QueryEscape(q), "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+		}
+		var rs []Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, r := range rs {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	got := search(" 600123456 ")
+	want := []string{"Someone Else", "600123456 Garcia", "Maria 600123456 Lopez"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if got := search("GARC"); len(got) != 1 || got[0] != "600123456 Garcia" {
+		t.Fatalf("expected a case insensitive name match, got %v", got)
+	}
+	if got := search("100%"); len(got) != 1 || got[0] != "Percent 100% off" {
+		t.Fatalf("expected the wildcards to be matched literally, got %v", got)
+	}
+	if got := search("_"); len(got) != 0 {
+		t.Fatalf("expected no match for an escaped wildcard, got %v", got)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/search", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without query, got %d", w.Code)
+	}
+}
+
+func TestPositionByPhone(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"lookup_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"other_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"+1 (555) 123-4567"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/2/reservation", `{"name":"customer_3","phone":"333333333"}`)
+
+	for _, phone := range []string{"+15551234567", "%2B15551234567", "1-555-123-4567"} {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/position?phone="+phone, "")
+		var p PhonePosition
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || p.ID != 2 || p.Position != 2 || p.PartiesAhead != 1 {
+			t.Fatalf("expected reservation 2 second in line for %s, got %d %s", phone, w.Code, w.Body.String())
+		}
+	}
+
+	for url, code := range map[string]int{
+		"/api/v1/queue/1/position?phone=333333333":  http.StatusNotFound,
+		"/api/v1/queue/1/position":                  http.StatusBadRequest,
+		"/api/v1/queue/42/position?phone=111111111": http.StatusNotFound,
+	} {
+		if w := doRequest(testApp, "GET", url, ""); w.Code != code {
+			t.Fatalf("expected %d for %s, got %d %s", code, url, w.Code, w.Body.String())
+		}
+	}
+}
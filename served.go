@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// ServedReservation is a reservation that left the queue after being served
+type ServedReservation struct {
+	ID            int64     `json:"id"`
+	ReservationID int64     `json:"reservationid"`
+	QueueID       int64     `json:"queueid"`
+	Number        int64     `json:"number"`
+	Name          string    `json:"name"`
+	Phone         string    `json:"phone"`
+	GroupSize     int64     `json:"groupsize"`
+	CreatedAt     time.Time `json:"created_at"`
+	ServedAt      time.Time `json:"served_at"`
+	// computed, not stored
+	WaitSeconds int64 `json:"wait_seconds"`
+}
+
+// ServedSummary aggregates the served reservations of a window
+type ServedSummary struct {
+	Count          int64 `json:"count"`
+	People         int64 `json:"people"`
+	AvgWaitSeconds int64 `json:"avg_wait_seconds"`
+}
+
+// ServedReport is the response of the served endpoint
+type ServedReport struct {
+	Served  []ServedReservation `json:"served"`
+	Summary ServedSummary       `json:"summary"`
+}
+
+// serve records the reservation in the served history and marks it served,
+// the parties behind it move up to close the gap. It returns sql.ErrNoRows
+// if the reservation is not waiting in the queue and
+// errInvalidConfirmation if the queue requires a code not matching it.
+func (a *App) serve(queueID, rsvp, code string) (ServedReservation, error) {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return ServedReservation{}, err
+	}
+	defer a.rollback(tx)
+	s, err := a.serveTx(tx, queueID, rsvp, code)
+	if err != nil {
+		return s, err
+	}
+	if err := a.commit(tx); err != nil {
+		return s, err
+	}
+	a.emit(Event{Type: EventServed, QueueID: s.QueueID, ReservationID: s.ReservationID, Time: s.ServedAt})
+	return s, nil
+}
+
+// serveTx serves the reservation as serve does in the transaction, for the
+// caller to commit and emit the service.
+func (a *App) serveTx(tx *sqlx.Tx, queueID, rsvp, code string) (ServedReservation, error) {
+	var s ServedReservation
+	var r Reservation
+	err := tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", queueID, rsvp)
+	if err != nil {
+		return s, err
+	}
+	var q Queue
+	if err := tx.Get(&q, "SELECT * FROM queue WHERE id=$1", queueID); err != nil {
+		return s, err
+	}
+	if err := checkConfirmation(q, r, code); err != nil {
+		return s, err
+	}
+	s = ServedReservation{
+		ReservationID: r.ID,
+		QueueID:       r.QueueID,
+		Number:        r.Number,
+		Name:          r.Name,
+		Phone:         r.Phone,
+		GroupSize:     r.GroupSize,
+		CreatedAt:     r.CreatedAt,
+		ServedAt:      a.now().UTC(),
+	}
+	res, err := tx.NamedExec(`INSERT INTO served (reservationid, queueid, number, name, phone, groupsize, created_at, served_at)
+		VALUES (:reservationid, :queueid, :number, :name, :phone, :groupsize, :created_at, :served_at)`, s)
+	if err != nil {
+		return s, err
+	}
+	if s.ID, err = res.LastInsertId(); err != nil {
+		return s, err
+	}
+	if _, err := tx.Exec("UPDATE reservation SET status=$1 WHERE id=$2", StatusServed, r.ID); err != nil {
+		return s, err
+	}
+	// the parties behind move up so the front is always at position 1
+	if _, err := tx.Exec("UPDATE reservation SET position = position - 1 WHERE queueid=$1 AND position > $2 AND status='waiting'", r.QueueID, r.Position); err != nil {
+		return s, err
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, StatusServed, gin.H{"from": StatusWaiting, "position": r.Position}); err != nil {
+		return s, err
+	}
+	s.WaitSeconds = int64(s.ServedAt.Sub(s.CreatedAt) / time.Second)
+	return s, nil
+}
+
+func (a *App) serveReservation(c *gin.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, err := a.serve(c.Param("id"), c.Param("rsvp"), c.Query("code"))
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err == errInvalidConfirmation {
+		abortWithError(c, http.StatusForbidden, "invalid_confirmation_code", "the confirmation code doesn't match the reservation")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// callable selects the waiting parties of the queue $1 that can be called
+// at $2, the held ones are skipped until their hold ends
+const callable = `queueid=$1 AND status='waiting' AND (held_until IS NULL OR held_until <= $2)`
+
+// upNext returns up to count parties in the order next serves them: the
+// callable parties in serving order or, with -priority-aging-rate, by aged
+// priority.
+func (a *App) upNext(queueID string, count int) ([]Reservation, error) {
+	now := a.now().UTC()
+	rs := []Reservation{}
+	if q, qerr := a.getQueue(queueID); a.priorityAging > 0 && qerr == nil && q.Strategy != "appointment" {
+		if err := a.db.Select(&rs, "SELECT * FROM reservation WHERE "+callable+" ORDER BY "+servingOrder, queueID, now); err != nil {
+			return nil, err
+		}
+		return a.agedOrder(rs, now, count), nil
+	}
+	err := a.db.Select(&rs, "SELECT * FROM reservation WHERE "+callable+" ORDER BY "+servingOrder+" LIMIT $3", queueID, now, count)
+	return rs, err
+}
+
+// next serves the party upNext returns first, it returns sql.ErrNoRows if
+// there is none.
+func (a *App) next(queueID, code string) (ServedReservation, error) {
+	rs, err := a.upNext(queueID, 1)
+	if err != nil {
+		return ServedReservation{}, err
+	}
+	if len(rs) == 0 {
+		return ServedReservation{}, sql.ErrNoRows
+	}
+	return a.serve(queueID, strconv.FormatInt(rs[0].ID, 10), code)
+}
+
+func (a *App) serveNext(c *gin.Context) {
+	if c.Query("table") != "" {
+		a.serveTable(c)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, err := a.next(c.Param("id"), c.Query("code"))
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_empty", "no party waiting to be served")
+		return
+	}
+	if err == errInvalidConfirmation {
+		abortWithError(c, http.StatusForbidden, "invalid_confirmation_code", "the confirmation code doesn't match the reservation")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// getServed returns the reservations served within the since and until
+// RFC3339 timestamps, both optional, ordered by the time they were served.
+func (a *App) getServed(c *gin.Context) {
+	id := c.Param("id")
+	since := time.Time{}
+	until := a.now().UTC()
+	for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := c.Query(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, "invalid_"+name, name+" must be a RFC3339 timestamp")
+				return
+			}
+			*t = parsed.UTC()
+		}
+	}
+
+	report := ServedReport{Served: []ServedReservation{}}
+	err := a.db.Select(&report.Served, `SELECT * FROM served WHERE queueid=$1 AND served_at >= $2 AND served_at <= $3
+		ORDER BY served_at ASC, id ASC`, id, since, until)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var totalWait int64
+	for i := range report.Served {
+		s := &report.Served[i]
+		s.WaitSeconds = int64(s.ServedAt.Sub(s.CreatedAt) / time.Second)
+		totalWait += s.WaitSeconds
+		report.Summary.Count++
+		report.Summary.People += s.GroupSize
+	}
+	if report.Summary.Count > 0 {
+		report.Summary.AvgWaitSeconds = totalWait / report.Summary.Count
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
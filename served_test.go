@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServedReport(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"served_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":4}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+
+	now = now.Add(10 * time.Minute)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status serving: %d %s", w.Code, w.Body.String())
+	}
+	now = now.Add(20 * time.Minute)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/2/serve", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status serving: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/2/serve", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 serving twice, got %d", w.Code)
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/served", "")
+	var report ServedReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Served) != 2 || report.Served[0].Name != "customer_1" || report.Served[1].Name != "customer_2" {
+		t.Fatalf("unexpected served reservations: %s", w.Body.String())
+	}
+	if report.Served[0].WaitSeconds != 600 || report.Served[1].WaitSeconds != 1800 {
+		t.Fatalf("unexpected wait durations: %s", w.Body.String())
+	}
+	if report.Summary.Count != 2 || report.Summary.People != 6 || report.Summary.AvgWaitSeconds != 1200 {
+		t.Fatalf("unexpected summary: %+v", report.Summary)
+	}
+
+	// the window only includes the second one
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/served?since=2022-01-01T20:15:00Z", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Served) != 1 || report.Served[0].Name != "customer_2" {
+		t.Fatalf("unexpected served reservations in the window: %s", w.Body.String())
+	}
+
+	// served parties leave the queue
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var waiting []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &waiting); err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 1 || waiting[0].Name != "customer_3" {
+		t.Fatalf("unexpected waiting reservations: %s", w.Body.String())
+	}
+}
+
+func TestServeNext(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"next_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333", "444444444"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	var s ServedReservation
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || s.ReservationID != 1 || s.Phone != "111111111" {
+		t.Fatalf("expected the front of the queue to be served, got %d %s", w.Code, w.Body.String())
+	}
+	var positions []int64
+	if err := testApp.db.Select(&positions, "SELECT position FROM reservation WHERE status='waiting' ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 3 || positions[0] != 1 || positions[1] != 2 || positions[2] != 3 {
+		t.Fatalf("expected the remaining parties renumbered from 1, got %v", positions)
+	}
+
+	// concurrent hosts never serve the same party
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	served := map[int64]bool{}
+	empty := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+			mu.Lock()
+			defer mu.Unlock()
+			if w.Code == http.StatusNotFound {
+				empty++
+				return
+			}
+			var s ServedReservation
+			json.Unmarshal(w.Body.Bytes(), &s)
+			served[s.ReservationID] = true
+		}()
+	}
+	wg.Wait()
+	if len(served) != 3 || empty != 1 {
+		t.Fatalf("expected 3 distinct parties served and 1 empty queue, got %v and %d", served, empty)
+	}
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	if e, _ := decodeError(w); w.Code != http.StatusNotFound || e.Code != "queue_empty" {
+		t.Fatalf("expected 404 queue_empty, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueueStats summarizes the current state of a queue
+type QueueStats struct {
+	// Parties are the reservations waiting and People their group sizes
+	Parties int64 `json:"parties"`
+	People  int64 `json:"people"`
+	// NextPosition is the position a new reservation would get
+	NextPosition int64         `json:"nextPosition"`
+	SLABreaches  []Reservation `json:"sla_breaches"`
+}
+
+// checkSLA flags the reservations that have been waiting longer than their
+// queue SLA, every reservation is flagged and reported only once.
+func (a *App) checkSLA() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var candidates []struct {
+		ID         int64     `json:"id"`
+		QueueID    int64     `json:"queueid"`
+		CreatedAt  time.Time `json:"created_at"`
+		SLASeconds int64     `json:"sla_seconds"`
+	}
+	err := a.db.Select(&candidates, `SELECT r.id, r.queueid, r.created_at, q.sla_seconds
+		FROM reservation r JOIN queue q ON q.id = r.queueid
+		WHERE q.sla_seconds > 0 AND r.sla_breached_at IS NULL AND r.status = 'waiting'`)
+	if err != nil {
+		return err
+	}
+	now := a.now().UTC()
+	for _, r := range candidates {
+		if now.Sub(r.CreatedAt) <= time.Duration(r.SLASeconds)*time.Second {
+			continue
+		}
+		_, err := a.db.Exec("UPDATE reservation SET sla_breached_at=$1 WHERE id=$2", now, r.ID)
+		if err != nil {
+			return err
+		}
+		a.metrics.inc("cola_sla_breaches_total")
+		a.emit(Event{Type: EventSLABreach, QueueID: r.QueueID, ReservationID: r.ID, Time: now})
+	}
+	return nil
+}
+
+func (a *App) getQueueStats(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := a.getQueue(id); err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	stats := QueueStats{SLABreaches: []Reservation{}}
+	err := a.db.QueryRowx(`SELECT COUNT(*), COALESCE(SUM(groupsize), 0), COALESCE(MAX(position), 0) + 1
+		FROM reservation WHERE queueid=$1 AND status='waiting'`, id).Scan(&stats.Parties, &stats.People, &stats.NextPosition)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	stats.NextPosition = a.reportPosition(stats.NextPosition)
+	err = a.db.Select(&stats.SLABreaches, "SELECT * FROM reservation WHERE queueid=$1 AND status='waiting' AND sla_breached_at IS NOT NULL ORDER BY position ASC, seq ASC", id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.reportPositions(stats.SLABreaches)
+	c.IndentedJSON(http.StatusOK, stats)
+}
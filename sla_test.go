@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSLABreach(t *testing.T) {
+	testApp := newTestApp(t)
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+	var mu sync.Mutex
+	var events []Event
+	testApp.listeners = append(testApp.listeners, func(ev Event) {
+		if ev.Type != EventSLABreach {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"sla_queue","sla_seconds":60}`); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status creating queue: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"old_customer","phone":"123456789"}`); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status creating reservation: %d %s", w.Code, w.Body.String())
+	}
+
+	// within the SLA
+	now = now.Add(30 * time.Second)
+	if err := testApp.checkSLA(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("unexpected events before the SLA expired: %v", events)
+	}
+
+	// over the SLA, the breach must be reported only once
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := testApp.checkSLA(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events) != 1 || events[0].Type != EventSLABreach || events[0].ReservationID != 1 {
+		t.Fatalf("expected one breach event, got %v", events)
+	}
+	if v := testApp.metrics.get("cola_sla_breaches_total"); v != 1 {
+		t.Fatalf("expected breach metric 1, got %v", v)
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/stats", "")
+	var stats QueueStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.SLABreaches) != 1 || stats.SLABreaches[0].Name != "old_customer" {
+		t.Fatalf("expected the breach in the stats, got %s", w.Body.String())
+	}
+
+	w = doRequest(testApp, "GET", "/metrics", "")
+	if !strings.Contains(w.Body.String(), "cola_sla_breaches_total 1") {
+		t.Fatalf("expected the breach in the metrics, got %s", w.Body.String())
+	}
+}
+
+func TestQueueStatsHeadcount(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	stats := func() QueueStats {
+		t.Helper()
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/stats", "")
+		var s QueueStats
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected stats: %d %s", w.Code, w.Body.String())
+		}
+		return s
+	}
+
+	if s := stats(); s.Parties != 0 || s.People != 0 || s.NextPosition != 1 {
+		t.Fatalf("expected an empty queue, got %+v", s)
+	}
+	for i, size := range []int{1, 4, 2} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000","groupsize":%d}`, i, i+1, size))
+	}
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/serve", "")
+	if s := stats(); s.Parties != 2 || s.People != 6 || s.NextPosition != 3 {
+		t.Fatalf("expected the 2 parties of 6 people left, got %+v", s)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/9/stats", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing queue, got %d %s", w.Code, w.Body.String())
+	}
+}
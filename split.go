@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SplitRequest splits a reservation in parties of the given sizes, the
+// first one keeps the reservation and its position.
+type SplitRequest struct {
+	Sizes []int64 `json:"sizes" binding:"required,min=2,dive,min=1"`
+	// Placement of the new parties: "back" of the queue, the default, or
+	// right "after" the original one
+	Placement string `json:"placement" binding:"omitempty,oneof=back after"`
+}
+
+func (a *App) splitReservation(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	var req SplitRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var total int64
+	for _, size := range req.Sizes {
+		total += size
+	}
+	if total != r.GroupSize {
+		abortWithError(c, http.StatusBadRequest, "invalid_sizes", "the sizes must add up to the group size")
+		return
+	}
+
+	extra := int64(len(req.Sizes) - 1)
+	var pos int64
+	if req.Placement == "after" {
+		// make room right behind the original party
+		_, err = tx.Exec("UPDATE reservation SET position = position + $1 WHERE queueid=$2 AND position > $3 AND status='waiting'", extra, id, r.Position)
+		pos = r.Position
+	} else {
+		err = tx.Get(&pos, "SELECT COALESCE(MAX(position), 0) FROM reservation WHERE queueid=$1 AND status='waiting'", id)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var number int64
+	err = tx.Get(&number, "SELECT COALESCE(MAX(number), (SELECT start_number FROM queue WHERE id=$1) - 1) FROM reservation WHERE queueid=$2", id, id)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := tx.Exec("UPDATE reservation SET groupsize=$1 WHERE id=$2", req.Sizes[0], r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	ids := []int64{r.ID}
+	for i, size := range req.Sizes[1:] {
+		party := r
+		party.GroupSize = size
+		party.Position = pos + int64(i) + 1
+		party.Number = number + int64(i) + 1
+		party.ParentID = &r.ID
+		res, err := tx.NamedExec(`INSERT INTO reservation (name, queueid, position, phone, groupsize, created_at, number, parentid,
+			confirmation_code, scheduled_at, return_url, email, priority, locale, attachments)
+			VALUES (:name, :queueid, :position, :phone, :groupsize, :created_at, :number, :parentid,
+			:confirmation_code, :scheduled_at, :return_url, :email, :priority, :locale, :attachments)`, party)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		partyID, err := res.LastInsertId()
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		ids = append(ids, partyID)
+	}
+	err = a.audit(tx, r.QueueID, r.ID, "split", gin.H{"sizes": req.Sizes, "reservations": ids})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventUpdated, QueueID: r.QueueID, ReservationID: r.ID})
+	for _, partyID := range ids[1:] {
+		a.emit(Event{Type: EventCreated, QueueID: r.QueueID, ReservationID: partyID})
+	}
+
+	parties := make([]Reservation, len(ids))
+	for i, partyID := range ids {
+		if err = a.db.Get(&parties[i], selectReservations+" WHERE id=$2", id, partyID); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = a.decorate(parties)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, parties)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSplitReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"split_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":6}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":2}`)
+
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/split", `{"sizes":[3,2]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 when the sizes don't add up, got %d %s", w.Code, w.Body.String())
+	}
+
+	var events []Event
+	testApp.listeners = append(testApp.listeners, func(ev Event) { events = append(events, ev) })
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/1/split", `{"sizes":[3,3]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status splitting: %d %s", w.Code, w.Body.String())
+	}
+	// the subscribers see the original party updated and the new one created
+	if len(events) != 2 || events[0].Type != EventUpdated || events[0].ReservationID != 1 ||
+		events[1].Type != EventCreated || events[1].ReservationID != 3 {
+		t.Fatalf("unexpected events splitting: %+v", events)
+	}
+	var parties []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &parties); err != nil {
+		t.Fatal(err)
+	}
+	if len(parties) != 2 {
+		t.Fatalf("expected two parties, got %s", w.Body.String())
+	}
+	// the first keeps the position, the rest goes to the back
+	if parties[0].ID != 1 || parties[0].GroupSize != 3 || parties[0].Position != 1 {
+		t.Fatalf("unexpected original party: %+v", parties[0])
+	}
+	if parties[1].GroupSize != 3 || parties[1].Position != 3 || parties[1].Phone != "111111111" ||
+		parties[1].ParentID == nil || *parties[1].ParentID != 1 {
+		t.Fatalf("unexpected new party: %+v", parties[1])
+	}
+
+	// placed right after the original party
+	w = doRequest(testApp, "POST", "/api/v1/queue/1/reservation/2/split", `{"sizes":[1,1],"placement":"after"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status splitting: %d %s", w.Code, w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/peek?count=10", "")
+	var all []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		name           string
+		size, position int64
+	}{
+		{"customer_1", 3, 1}, {"customer_2", 1, 2}, {"customer_2", 1, 3}, {"customer_1", 3, 4},
+	}
+	if len(all) != len(expected) {
+		t.Fatalf("unexpected queue after splitting: %s", w.Body.String())
+	}
+	for i, e := range expected {
+		if all[i].Name != e.name || all[i].GroupSize != e.size || all[i].Position != e.position {
+			t.Fatalf("unexpected party %d after splitting: %+v", i, all[i])
+		}
+	}
+
+	var audits []AuditEntry
+	if err := testApp.db.Select(&audits, "SELECT * FROM audit WHERE action='split' ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 2 || audits[0].ReservationID != 1 || audits[0].Detail != `{"reservations":[1,3],"sizes":[3,3]}` {
+		t.Fatalf("unexpected audit entries: %+v", audits)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build sqlcipher
+// +build sqlcipher
+
+package main
+
+import (
+	// registers itself as sqlite3, replacing the plain driver
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// encryptionSupported is whether the sqlite3 driver can encrypt the
+// database, SQLCipher does with the key of -db-key.
+const encryptionSupported = true
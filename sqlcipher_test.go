@@ -0,0 +1,46 @@
+//go:build sqlcipher
+// +build sqlcipher
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestEncryptedDatabase(t *testing.T) {
+	dbname := t.TempDir() + "/encrypted.db"
+	databaseKey = "s3cr3t"
+	defer func() { databaseKey = "" }()
+
+	testApp := NewApp(dbname)
+	if w := doRequest(testApp, "POST", "/api/v1/queue", `{"name":"secret_queue"}`); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status creating a queue: %d %s", w.Code, w.Body.String())
+	}
+	testApp.db.Close()
+
+	// the file can't be read without the key
+	db, err := sqlx.Connect("sqlite3", dbname)
+	if err == nil {
+		var n int
+		err = db.Get(&n, "SELECT COUNT(*) FROM queue")
+		db.Close()
+	}
+	if err == nil {
+		t.Fatalf("expected the database to be unreadable without the key")
+	}
+
+	testApp = NewApp(dbname)
+	defer testApp.db.Close()
+	w := doRequest(testApp, "GET", "/api/v1/queue/1", "")
+	var q Queue
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || q.Name != "secret_queue" {
+		t.Fatalf("expected the queue back with the key, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !sqlcipher
+// +build !sqlcipher
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// encryptionSupported is whether the sqlite3 driver can encrypt the
+// database, build with the sqlcipher tag for it.
+const encryptionSupported = false
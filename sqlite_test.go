@@ -0,0 +1,15 @@
+//go:build !sqlcipher
+// +build !sqlcipher
+
+package main
+
+import "testing"
+
+func TestDatabaseKeyUnsupported(t *testing.T) {
+	if dsn, err := withDatabaseKey("cola.db", ""); err != nil || dsn != "cola.db" {
+		t.Fatalf("expected no key to keep the DSN, got %q: %v", dsn, err)
+	}
+	if _, err := withDatabaseKey("cola.db", "s3cr3t"); err == nil {
+		t.Fatalf("expected a key to be rejected without the sqlcipher tag")
+	}
+}
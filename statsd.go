@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// statsd pushes the metrics to a StatsD server over UDP, counters are sent
+// as the increment since the previous push and gauges as their value.
+type statsd struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	prefix string
+	// last pushed value of the counters
+	last map[string]float64
+}
+
+func newStatsd(addr, prefix string) (*statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsd{conn: conn, prefix: prefix, last: map[string]float64{}}, nil
+}
+
+// statsdName flattens the labels of a sample into the metric name, e.g.
+// cola_subscribers{queue="1"} is cola_subscribers.queue_1
+func statsdName(s sample) string {
+	if s.labels == "" {
+		return s.name
+	}
+	r := strings.NewReplacer(`="`, "_", `"`, "", ",", ".", " ", "_", ":", "_", "|", "_")
+	return s.name + "." + r.Replace(s.labels)
+}
+
+// push sends the current values of the metrics, one packet per metric so it
+// never exceeds the datagram size.
+func (s *statsd) push(m *metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range m.snapshot() {
+		for _, smp := range f.samples {
+			name := s.prefix + statsdName(smp)
+			var line string
+			if f.counter {
+				delta := smp.value - s.last[name]
+				if delta == 0 {
+					continue
+				}
+				s.last[name] = smp.value
+				line = fmt.Sprintf("%s:%g|c", name, delta)
+			} else {
+				line = fmt.Sprintf("%s:%g|g", name, smp.value)
+			}
+			if _, err := s.conn.Write([]byte(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *statsd) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdPush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testApp := newTestApp(t)
+	s, err := newStatsd(conn.LocalAddr().String(), "cola.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"statsd_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	if err := s.push(testApp.metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		var lines []string
+		buf := make([]byte, 1024)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			lines = append(lines, string(buf[:n]))
+		}
+		return strings.Join(lines, "\n")
+	}
+	got := read()
+	if !strings.Contains(got, "cola.cola_reservations_created_total:1|c") {
+		t.Fatalf("expected the reservations counter to be pushed, got %q", got)
+	}
+	if strings.Contains(got, "cola_sla_breaches_total") {
+		t.Fatalf("expected unchanged counters not to be pushed, got %q", got)
+	}
+
+	// counters are pushed as increments
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	if err := s.push(testApp.metrics); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); !strings.Contains(got, "cola.cola_reservations_created_total:1|c") {
+		t.Fatalf("expected an increment of 1, got %q", got)
+	}
+}
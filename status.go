@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReservationStatus is the customer facing view of a reservation
+type ReservationStatus struct {
+	ID                   int64     `json:"id"`
+	Ticket               string    `json:"ticket"`
+	Position             int64     `json:"position"`
+	PartiesAhead         int64     `json:"parties_ahead"`
+	PeopleAhead          int64     `json:"people_ahead"`
+	EstimatedWaitSeconds int64     `json:"estimated_wait_seconds"`
+	EstimatedReadyAt     time.Time `json:"estimated_ready_at"`
+	// ScheduledAt and SecondsUntilAppointment are reported for the
+	// appointments of the queues in appointment mode
+	ScheduledAt             *time.Time `json:"scheduled_at,omitempty"`
+	SecondsUntilAppointment *int64     `json:"seconds_until_appointment,omitempty"`
+	// QueueClosed is set while the queue of the reservation is closed
+	QueueClosed bool `json:"queue_closed,omitempty"`
+	// Message is localized with the Accept-Language of the request
+	Message string `json:"message"`
+}
+
+// BandedStatus is the customer facing view of a reservation of a queue
+// reporting bands of positions instead of the exact ones
+type BandedStatus struct {
+	ID                      int64      `json:"id"`
+	Ticket                  string     `json:"ticket"`
+	PositionBand            string     `json:"position_band"`
+	EstimatedWaitSeconds    int64      `json:"estimated_wait_seconds"`
+	EstimatedReadyAt        time.Time  `json:"estimated_ready_at"`
+	ScheduledAt             *time.Time `json:"scheduled_at,omitempty"`
+	SecondsUntilAppointment *int64     `json:"seconds_until_appointment,omitempty"`
+	Message                 string     `json:"message"`
+}
+
+// positionBand returns the band of size positions the stored 1-based
+// position is in, e.g. 6-10, in the configured base.
+func (a *App) positionBand(position, size int64) string {
+	first := (position-1)/size*size + 1
+	return fmt.Sprintf("%d-%d", a.reportPosition(first), a.reportPosition(first+size-1))
+}
+
+// Estimate is the expected wait of a party if it joined the queue now
+type Estimate struct {
+	GroupSize            int64     `json:"groupsize"`
+	Position             int64     `json:"position"`
+	PartiesAhead         int64     `json:"parties_ahead"`
+	PeopleAhead          int64     `json:"people_ahead"`
+	EstimatedWaitSeconds int64     `json:"estimated_wait_seconds"`
+	EstimatedReadyAt     time.Time `json:"estimated_ready_at"`
+}
+
+// estimateWait returns the expected wait of a party with partiesAhead
+// parties to be served before it.
+func (a *App) estimateWait(partiesAhead int64) time.Duration {
+	return time.Duration(partiesAhead) * a.avgWait
+}
+
+// setEstimates computes the wait estimates from the serving position of the
+// reservations, the reservations without it are left untouched. The
+// appointments of the queues in appointment mode are ready at their time.
+func (a *App) setEstimates(rs []Reservation) {
+	now := a.now().UTC()
+	for i := range rs {
+		if rs[i].GroupPosition == 0 {
+			continue
+		}
+		wait := a.estimateWait(rs[i].GroupPosition - 1)
+		if a.isAppointment(rs[i]) {
+			wait = rs[i].ScheduledAt.Sub(now)
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		ready := now.Add(wait)
+		rs[i].EstimatedWaitSeconds = int64(wait / time.Second)
+		rs[i].EstimatedReadyAt = &ready
+	}
+}
+
+// reportPosition converts a stored 1-based position to the configured base
+func (a *App) reportPosition(position int64) int64 {
+	return position - 1 + a.positionBase
+}
+
+// reportPositions converts the positions of the reservations to the
+// configured base, it must be the last step once nothing else reads them.
+func (a *App) reportPositions(rs []Reservation) {
+	for i := range rs {
+		rs[i].Position = a.reportPosition(rs[i].Position)
+		if rs[i].GroupPosition == 0 {
+			continue
+		}
+		rs[i].GroupPosition = a.reportPosition(rs[i].GroupPosition)
+		rs[i].PersonPosition = a.reportPosition(rs[i].PersonPosition)
+	}
+}
+
+// isAppointment returns if the reservation is an appointment of a queue in
+// appointment mode.
+func (a *App) isAppointment(r Reservation) bool {
+	if r.ScheduledAt == nil {
+		return false
+	}
+	q, err := a.getQueue(strconv.FormatInt(r.QueueID, 10))
+	return err == nil && q.Strategy == "appointment"
+}
+
+func (a *App) getReservationStatus(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	lang := a.i18n.lang(c.GetHeader("Accept-Language"))
+	var r Reservation
+	err := a.db.Get(&r, selectReservations+" WHERE id=$2", id, rsvp)
+	if err == sql.ErrNoRows {
+		c.Header("Content-Language", lang)
+		if closed, err := a.closedReservation(id, rsvp); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		} else if closed {
+			abortWithError(c, http.StatusGone, "queue_closed", a.i18n.message(lang, "status.closed", nil))
+			return
+		}
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", a.i18n.message(lang, "reservation_not_found", nil))
+		return
+	}
+	// the locale of the party applies when the client doesn't ask for one
+	if c.GetHeader("Accept-Language") == "" && r.Locale != "" {
+		lang = r.Locale
+	}
+	c.Header("Content-Language", lang)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	rs := []Reservation{r}
+	if err := a.decorate(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// the ahead counts don't depend on the base
+	partiesAhead := r.GroupPosition - 1
+	peopleAhead := r.PersonPosition - r.GroupSize
+	r = rs[0]
+	s := ReservationStatus{
+		ID:                   r.ID,
+		Ticket:               r.Ticket,
+		Position:             r.GroupPosition,
+		PartiesAhead:         partiesAhead,
+		PeopleAhead:          peopleAhead,
+		EstimatedWaitSeconds: r.EstimatedWaitSeconds,
+		EstimatedReadyAt:     *r.EstimatedReadyAt,
+	}
+	if a.isAppointment(r) {
+		until := int64(r.ScheduledAt.Sub(a.now()) / time.Second)
+		s.ScheduledAt = r.ScheduledAt
+		s.SecondsUntilAppointment = &until
+		if until > 0 {
+			s.Message = a.i18n.message(lang, "status.appointment", map[string]int64{"Minutes": (until + 59) / 60})
+		} else {
+			s.Message = a.i18n.message(lang, "status.appointment_now", nil)
+		}
+	} else if s.PartiesAhead == 0 {
+		s.Message = a.i18n.message(lang, "status.next", s)
+	} else {
+		s.Message = a.i18n.message(lang, "status.position", s)
+	}
+	q, err := a.getQueue(id)
+	if err == nil && q.ClosedAt != nil {
+		s.QueueClosed = true
+		s.Message = a.i18n.message(lang, "status.closed", nil)
+	}
+	// the staff always sees the exact position
+	if err == nil && q.PositionBandSize > 0 && !a.isStaff(c) {
+		b := BandedStatus{
+			ID:                      s.ID,
+			Ticket:                  s.Ticket,
+			PositionBand:            a.positionBand(partiesAhead+1, q.PositionBandSize),
+			EstimatedWaitSeconds:    s.EstimatedWaitSeconds,
+			EstimatedReadyAt:        s.EstimatedReadyAt,
+			ScheduledAt:             s.ScheduledAt,
+			SecondsUntilAppointment: s.SecondsUntilAppointment,
+			Message:                 s.Message,
+		}
+		if s.ScheduledAt == nil && !s.QueueClosed {
+			b.Message = a.i18n.message(lang, "status.position_band", b)
+		}
+		c.IndentedJSON(http.StatusOK, b)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, s)
+}
+
+// getEstimate estimates the wait of a party of groupsize people appended at
+// the back of the queue, without creating the reservation.
+func (a *App) getEstimate(c *gin.Context) {
+	id := c.Param("id")
+	e := Estimate{GroupSize: 1}
+	if v := c.Query("groupsize"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			abortWithError(c, http.StatusBadRequest, "invalid_request", "groupsize must be a positive integer")
+			return
+		}
+		e.GroupSize = n
+	}
+	q, err := a.getQueue(id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if msg := q.checkGroupSize(e.GroupSize); msg != "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_group_size", msg)
+		return
+	}
+	err = a.db.QueryRowx("SELECT COUNT(*), COALESCE(SUM(groupsize), 0) FROM reservation WHERE queueid=$1 AND status='waiting'", id).
+		Scan(&e.PartiesAhead, &e.PeopleAhead)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	wait := a.estimateWait(e.PartiesAhead)
+	e.Position = a.reportPosition(e.PartiesAhead + 1)
+	e.EstimatedWaitSeconds = int64(wait / time.Second)
+	e.EstimatedReadyAt = a.now().UTC().Add(wait)
+	c.IndentedJSON(http.StatusOK, e)
+}
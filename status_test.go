@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimatedReadyAt(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.avgWait = 6 * time.Minute
+	now := time.Date(2022, 1, 1, 19, 27, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	// two parties ahead
+	if r.EstimatedWaitSeconds != 720 || r.EstimatedReadyAt == nil || !r.EstimatedReadyAt.Equal(now.Add(12*time.Minute)) {
+		t.Fatalf("unexpected estimate creating the reservation: %s", w.Body.String())
+	}
+
+	// recomputed on every read
+	now = now.Add(3 * time.Minute)
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3/status", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d %s", w.Code, w.Body.String())
+	}
+	var s ReservationStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Position != 3 || s.PartiesAhead != 2 || s.PeopleAhead != 3 || s.EstimatedWaitSeconds != 720 {
+		t.Fatalf("unexpected status: %s", w.Body.String())
+	}
+	if !s.EstimatedReadyAt.Equal(now.Add(720 * time.Second)) {
+		t.Fatalf("expected ready at %v, got %v", now.Add(720*time.Second), s.EstimatedReadyAt)
+	}
+}
+
+func TestEstimatedWaitOfReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.avgWait = 5 * time.Minute
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"estimate_queue"}`)
+	for _, phone := range []string{"111111111", "222222222", "333333333"} {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_`+phone+`","phone":"`+phone+`"}`)
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3", "")
+	var r Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.GroupPosition != 3 || r.EstimatedWaitSeconds != 600 {
+		t.Fatalf("expected 10m at position 3, got %s", w.Body.String())
+	}
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+	var rs []Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range rs {
+		if r.EstimatedWaitSeconds != int64(i*300) {
+			t.Fatalf("unexpected estimates listing the reservations: %s", w.Body.String())
+		}
+	}
+
+	// the estimate follows the current position
+	doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.EstimatedWaitSeconds != 300 {
+		t.Fatalf("expected 5m once the queue moved, got %s", w.Body.String())
+	}
+}
+
+func TestEstimateNewParty(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.avgWait = 5 * time.Minute
+	now := time.Date(2022, 1, 1, 19, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue","max_group_size":6}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222","groupsize":3}`)
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/estimate?groupsize=4", "")
+	var e Estimate
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || e.GroupSize != 4 || e.Position != 3 || e.PartiesAhead != 2 || e.PeopleAhead != 5 {
+		t.Fatalf("unexpected estimate: %d %s", w.Code, w.Body.String())
+	}
+	// nothing is created
+	var n int
+	if err := testApp.db.Get(&n, "SELECT COUNT(*) FROM reservation"); err != nil || n != 2 {
+		t.Fatalf("expected the estimate not to create reservations, got %d %v", n, err)
+	}
+
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333","groupsize":4}`)
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3/status", "")
+	var s ReservationStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Position != e.Position || s.PartiesAhead != e.PartiesAhead || s.PeopleAhead != e.PeopleAhead ||
+		s.EstimatedWaitSeconds != e.EstimatedWaitSeconds || !s.EstimatedReadyAt.Equal(e.EstimatedReadyAt) {
+		t.Fatalf("expected the status %s to match the estimate %+v", w.Body.String(), e)
+	}
+
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/estimate?groupsize=7", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 over the maximum group size, got %d", w.Code)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/estimate?groupsize=zero", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid group size, got %d", w.Code)
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/9/estimate", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown queue, got %d", w.Code)
+	}
+}
+
+func TestPositionBase(t *testing.T) {
+	for _, base := range []int64{1, 0} {
+		t.Run(fmt.Sprintf("base_%d", base), func(t *testing.T) {
+			testApp := newTestApp(t)
+			testApp.positionBase = base
+			doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+			doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111","groupsize":2}`)
+			w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+			var r Reservation
+			if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Position != base+1 || r.GroupPosition != base+1 || r.PersonPosition != base+2 {
+				t.Fatalf("base %d: unexpected positions creating the reservation: %s", base, w.Body.String())
+			}
+
+			w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+			var rs []Reservation
+			if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+				t.Fatal(err)
+			}
+			if len(rs) != 2 || rs[0].Position != base || rs[0].GroupPosition != base || rs[1].Position != base+1 {
+				t.Fatalf("base %d: unexpected positions listing: %s", base, w.Body.String())
+			}
+
+			w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/1/status", "")
+			var s ReservationStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+				t.Fatal(err)
+			}
+			if s.Position != base || s.PartiesAhead != 0 || s.PeopleAhead != 0 {
+				t.Fatalf("base %d: unexpected status of the first party: %s", base, w.Body.String())
+			}
+			w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/2/status", "")
+			s = ReservationStatus{}
+			if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+				t.Fatal(err)
+			}
+			if s.Position != base+1 || s.PartiesAhead != 1 || s.PeopleAhead != 2 {
+				t.Fatalf("base %d: unexpected status of the second party: %s", base, w.Body.String())
+			}
+
+			w = doRequest(testApp, "GET", "/api/v1/queue/1/estimate", "")
+			var e Estimate
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Position != base+2 || e.PartiesAhead != 2 {
+				t.Fatalf("base %d: unexpected estimate: %s", base, w.Body.String())
+			}
+
+			// stored 1-based regardless of the base
+			var stored []int64
+			if err := testApp.db.Select(&stored, "SELECT position FROM reservation ORDER BY id"); err != nil || len(stored) != 2 || stored[0] != 1 || stored[1] != 2 {
+				t.Fatalf("base %d: expected the positions stored 1-based, got %v %v", base, stored, err)
+			}
+		})
+	}
+}
+
+func TestPositionBand(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.adminToken = "s3cr3t"
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"fair_queue","position_band_size":5}`)
+	for i := 1; i <= 7; i++ {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000"}`, i, i))
+	}
+
+	for rsvp, band := range map[string]string{"1": "1-5", "5": "1-5", "7": "6-10"} {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/"+rsvp+"/status", "")
+		var b BandedStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || b.PositionBand != band || strings.Contains(w.Body.String(), `"position"`) {
+			t.Fatalf("expected reservation %s in band %s without the exact position, got %s", rsvp, band, w.Body.String())
+		}
+	}
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/7/status", "")
+	if !strings.Contains(w.Body.String(), "between numbers 6-10") {
+		t.Fatalf("expected the message to report the band, got %s", w.Body.String())
+	}
+
+	// the staff sees the exact position
+	req := httptest.NewRequest("GET", "/api/v1/queue/1/reservation/7/status", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	rec := httptest.NewRecorder()
+	testApp.router.ServeHTTP(rec, req)
+	var s ReservationStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || s.Position != 7 || s.PartiesAhead != 6 || strings.Contains(rec.Body.String(), "position_band") {
+		t.Fatalf("expected the exact position for the staff, got %s", rec.Body.String())
+	}
+
+	// with base 0 the bands start at 0
+	testApp.positionBase = 0
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/7/status", "")
+	if !strings.Contains(w.Body.String(), `"position_band": "5-9"`) {
+		t.Fatalf("expected the band 5-9 with base 0, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bestFitWindow is how many of the front parties best-fit combines, the
+// combinations grow exponentially with it
+const bestFitWindow = 16
+
+// TableServed is the response of serving the parties combined for a table
+type TableServed struct {
+	Table  int64               `json:"table"`
+	Seated int64               `json:"seated"`
+	Served []ServedReservation `json:"served"`
+}
+
+// fitGreedy returns the indexes of the parties taken in order as long as
+// they fit the seats left, the front party fitting is always taken.
+func fitGreedy(sizes []int64, table int64) []int {
+	var picked []int
+	left := table
+	for i, size := range sizes {
+		if size <= left {
+			picked = append(picked, i)
+			left -= size
+		}
+	}
+	return picked
+}
+
+// fitBest returns the indexes of the parties, among the first
+// bestFitWindow, filling the most seats of the table, between the
+// combinations filling the same seats the one with the front-most parties.
+func fitBest(sizes []int64, table int64) []int {
+	if len(sizes) > bestFitWindow {
+		sizes = sizes[:bestFitWindow]
+	}
+	best, bestSum := uint32(0), int64(0)
+	for mask := uint32(1); mask < 1<<len(sizes); mask++ {
+		var sum int64
+		for i := range sizes {
+			if mask&(1<<i) != 0 {
+				sum += sizes[i]
+			}
+		}
+		if sum > table || sum < bestSum {
+			continue
+		}
+		// the lowest party not in both decides
+		if diff := mask ^ best; sum > bestSum || mask&(diff&-diff) != 0 {
+			best, bestSum = mask, sum
+		}
+	}
+	var picked []int
+	for i := range sizes {
+		if best&(1<<i) != 0 {
+			picked = append(picked, i)
+		}
+	}
+	return picked
+}
+
+// serveTable serves together the waiting parties, in serving order and not
+// held, combined by -table-fit to fill a table with the ?table= seats.
+func (a *App) serveTable(c *gin.Context) {
+	id := c.Param("id")
+	table, err := strconv.ParseInt(c.Query("table"), 10, 64)
+	if err != nil || table < 1 {
+		abortWithError(c, http.StatusBadRequest, "invalid_table", "table must be a positive number of seats")
+		return
+	}
+	q, err := a.getQueue(id)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "queue_not_found", "queue not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// each party has its own code
+	if q.RequireConfirmation {
+		abortWithError(c, http.StatusConflict, "confirmation_required", "the parties of a queue requiring confirmation are served one by one")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// the parties are seated together or none is
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var waiting []Reservation
+	err = tx.Select(&waiting, "SELECT * FROM reservation WHERE "+callable+" ORDER BY "+servingOrder, id, a.now().UTC())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	sizes := make([]int64, len(waiting))
+	for i, r := range waiting {
+		sizes[i] = r.GroupSize
+	}
+	fit := fitGreedy
+	if a.tableFit == "best" {
+		fit = fitBest
+	}
+	picked := fit(sizes, table)
+	if len(picked) == 0 {
+		abortWithError(c, http.StatusNotFound, "queue_empty", "no party waiting fits the table")
+		return
+	}
+	res := TableServed{Table: table, Served: []ServedReservation{}}
+	for _, i := range picked {
+		s, err := a.serveTx(tx, id, strconv.FormatInt(waiting[i].ID, 10), "")
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		res.Seated += s.GroupSize
+		res.Served = append(res.Served, s)
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, s := range res.Served {
+		a.emit(Event{Type: EventServed, QueueID: s.QueueID, ReservationID: s.ReservationID, Time: s.ServedAt})
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestServeTable(t *testing.T) {
+	testApp := newTestApp(t)
+	join := func(queue string, sizes ...int) {
+		t.Helper()
+		doRequest(testApp, "POST", "/api/v1/queue", `{"name":"table_queue_`+queue+`"}`)
+		for i, size := range sizes {
+			body := fmt.Sprintf(`{"name":"customer_%s%d","phone":"%s0000000%d","groupsize":%d}`, queue, i, queue, i, size)
+			if w := doRequest(testApp, "POST", "/api/v1/queue/"+queue+"/reservation", body); w.Code != http.StatusCreated {
+				t.Fatalf("unexpected reservation: %d %s", w.Code, w.Body.String())
+			}
+		}
+	}
+	seat := func(queue string, table int) []int64 {
+		t.Helper()
+		w := doRequest(testApp, "POST", fmt.Sprintf("/api/v1/queue/%s/next?table=%d", queue, table), "")
+		var res TableServed
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected table: %d %s", w.Code, w.Body.String())
+		}
+		ids := []int64{}
+		var seated int64
+		for _, s := range res.Served {
+			ids = append(ids, s.ReservationID)
+			seated += s.GroupSize
+		}
+		if res.Seated != seated || res.Table != int64(table) {
+			t.Fatalf("unexpected table summary: %+v", res)
+		}
+		return ids
+	}
+
+	t.Run("greedy", func(t *testing.T) {
+		join("1", 3, 2, 1)
+		// each party is announced once all are seated
+		var announced []string
+		testApp.listeners = append(testApp.listeners, func(ev Event) {
+			var seated int
+			testApp.db.Get(&seated, "SELECT COUNT(*) FROM reservation WHERE queueid=1 AND status='served'")
+			announced = append(announced, fmt.Sprintf("%s %d %d", ev.Type, ev.ReservationID, seated))
+		})
+		if ids := seat("1", 4); fmt.Sprint(ids) != "[1 3]" {
+			t.Fatalf("expected the parties of 3 and 1 combined, got %v", ids)
+		}
+		testApp.listeners = testApp.listeners[:len(testApp.listeners)-1]
+		if fmt.Sprint(announced) != "[served 1 2 served 3 2]" {
+			t.Fatalf("expected both services emitted after they were committed, got %v", announced)
+		}
+		var rs []Reservation
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil || len(rs) != 1 || rs[0].ID != 2 || rs[0].Position != 1 {
+			t.Fatalf("expected the party of 2 left at the front, got %s", w.Body.String())
+		}
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/next?table=1", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 when no party fits, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("best fit", func(t *testing.T) {
+		testApp.tableFit = "best"
+		join("2", 3, 2, 2, 1)
+		if ids := seat("2", 4); fmt.Sprint(ids) != "[4 7]" {
+			t.Fatalf("expected the front-most full table, got %v", ids)
+		}
+		if ids := seat("2", 5); fmt.Sprint(ids) != "[5 6]" {
+			t.Fatalf("expected the parties of 2 combined, got %v", ids)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, table := range []string{"0", "-1", "four"} {
+			if w := doRequest(testApp, "POST", "/api/v1/queue/1/next?table="+table, ""); w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 for table %s, got %d %s", table, w.Code, w.Body.String())
+			}
+		}
+	})
+}
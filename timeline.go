@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimelineEvent is something that happened to a reservation
+type TimelineEvent struct {
+	Type   string          `json:"type"`
+	Time   time.Time       `json:"time"`
+	Detail json.RawMessage `json:"detail,omitempty"`
+}
+
+// getTimeline merges the history of the reservation, waiting or already
+// served, from its audit entries, its SLA breach and its service, ordered by
+// time.
+func (a *App) getTimeline(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	timeline := []TimelineEvent{}
+
+	var r Reservation
+	err := a.db.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+	if err != nil && err != sql.ErrNoRows {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	found := err == nil
+	var s ServedReservation
+	err = a.db.Get(&s, "SELECT * FROM served WHERE queueid=$1 AND reservationid=$2 ORDER BY id DESC LIMIT 1", id, rsvp)
+	if err != nil && err != sql.ErrNoRows {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// the reservations served before they were kept are only in the history,
+	// and their ids can be reused by new ones
+	served := err == nil && (!found || r.Status == StatusServed)
+	var created time.Time
+	switch {
+	case found:
+		created = r.CreatedAt
+	case served:
+		created = s.CreatedAt
+	default:
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	timeline = append(timeline, TimelineEvent{Type: "created", Time: created})
+
+	var audits []AuditEntry
+	if err := a.db.Select(&audits, "SELECT * FROM audit WHERE queueid=$1 AND reservationid=$2 ORDER BY id ASC", id, rsvp); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, e := range audits {
+		// the creation is already first, from the reservation, and the
+		// service comes from the served history
+		if e.CreatedAt.Before(created) || e.Action == "created" || e.Action == StatusServed {
+			continue
+		}
+		ev := TimelineEvent{Type: e.Action, Time: e.CreatedAt}
+		if e.Detail != "" && e.Detail != "null" {
+			ev.Detail = json.RawMessage(e.Detail)
+		}
+		timeline = append(timeline, ev)
+	}
+	if found && r.SLABreachedAt != nil {
+		timeline = append(timeline, TimelineEvent{Type: "sla_breached", Time: *r.SLABreachedAt})
+	}
+	if served {
+		timeline = append(timeline, TimelineEvent{Type: "served", Time: s.ServedAt})
+	}
+	// the events at the same time keep the order they were added in
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Time.Before(timeline[j].Time) })
+	c.IndentedJSON(http.StatusOK, timeline)
+}
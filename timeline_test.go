@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReservationTimeline(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.avgWait = 10 * time.Minute
+	now := time.Date(2022, 1, 1, 19, 0, 0, 0, time.UTC)
+	testApp.now = func() time.Time { return now }
+
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue","notify_lead_seconds":600}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	now = now.Add(time.Minute)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_3","phone":"333333333"}`)
+
+	now = now.Add(time.Minute)
+	doRequest(testApp, "PUT", "/api/v1/queue/1/reservation/2", `{"name":"customer_2","phone":"222222223"}`)
+	// customer_1 leaves, moving customer_2 to the front
+	now = now.Add(time.Minute)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/reservation/bulk-status", `{"ids":[1],"status":"cancelled"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status cancelling: %d %s", w.Code, w.Body.String())
+	}
+	now = now.Add(time.Minute)
+	if err := testApp.checkNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if w := doRequest(testApp, "POST", "/api/v1/queue/1/next", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status serving: %d %s", w.Code, w.Body.String())
+	}
+
+	w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/2/timeline", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", w.Code, w.Body.String())
+	}
+	var timeline []TimelineEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 1, 1, 19, 1, 0, 0, time.UTC)
+	expected := []struct {
+		kind    string
+		minutes int
+	}{{"created", 0}, {"phone_changed", 1}, {"moved", 2}, {"notified", 3}, {"served", 4}}
+	if len(timeline) != len(expected) {
+		t.Fatalf("unexpected timeline: %s", w.Body.String())
+	}
+	for i, e := range expected {
+		if timeline[i].Type != e.kind || !timeline[i].Time.Equal(start.Add(time.Duration(e.minutes)*time.Minute)) {
+			t.Fatalf("expected %s at +%dm as event %d, got %s", e.kind, e.minutes, i, w.Body.String())
+		}
+	}
+	var moved struct{ From, To int64 }
+	if err := json.Unmarshal(timeline[2].Detail, &moved); err != nil || moved.From != 2 || moved.To != 1 {
+		t.Fatalf("unexpected move detail %s: %v", timeline[2].Detail, err)
+	}
+
+	// still waiting
+	w = doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3/timeline", "")
+	timeline = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 3 || timeline[0].Type != "created" || timeline[1].Type != "moved" || timeline[2].Type != "notified" {
+		t.Fatalf("unexpected timeline of a waiting reservation: %s", w.Body.String())
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/9/timeline", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown reservation, got %d", w.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the statuses of a reservation, only the waiting ones are in the queue and
+// the others are kept as the history of the queue
+const (
+	StatusWaiting   = "waiting"
+	StatusServed    = "served"
+	StatusCancelled = "cancelled"
+	StatusNoShow    = "no_show"
+)
+
+// validStatus returns if the status is one of the reservation statuses
+func validStatus(status string) bool {
+	switch status {
+	case StatusWaiting, StatusServed, StatusCancelled, StatusNoShow:
+		return true
+	}
+	return false
+}
+
+// StatusRequest moves a waiting reservation to a final status
+type StatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=served cancelled no_show"`
+}
+
+// getLeftReservations returns the reservations of the queue with a final
+// status in the order they joined, they have no position.
+func (a *App) getLeftReservations(c *gin.Context, status string) {
+	reservations := []Reservation{}
+	err := a.db.Select(&reservations, "SELECT * FROM reservation WHERE queueid=$1 AND status=$2 ORDER BY seq ASC", c.Param("id"), status)
+	if err == nil {
+		for i := range reservations {
+			reservations[i].ConfirmationCode = ""
+		}
+		err = a.setTickets(reservations)
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, reservations)
+}
+
+// setReservationStatus moves a waiting reservation to a final status, served
+// as when serving it, and the parties behind it move up. The reservations
+// that already left the queue can't change their status.
+func (a *App) setReservationStatus(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	var req StatusRequest
+	if !a.bindJSON(c, &req) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var current string
+	err := a.db.Get(&current, "SELECT status FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if current != StatusWaiting {
+		abortWithError(c, http.StatusConflict, "invalid_transition", "reservation is already "+current)
+		return
+	}
+	if req.Status == StatusServed {
+		s, err := a.serve(id, rsvp, c.Query("code"))
+		if err == errInvalidConfirmation {
+			abortWithError(c, http.StatusForbidden, "invalid_confirmation_code", "the confirmation code doesn't match the reservation")
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		c.IndentedJSON(http.StatusOK, s)
+		return
+	}
+
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	if err := tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2", id, rsvp); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := tx.Exec("UPDATE reservation SET status=$1 WHERE id=$2", req.Status, r.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, req.Status, gin.H{"from": StatusWaiting, "position": r.Position}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if _, err := a.resequence(tx, id); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	a.emit(Event{Type: EventDeleted, QueueID: r.QueueID, ReservationID: r.ID})
+	r.Status = req.Status
+	r.ConfirmationCode = ""
+	rs := []Reservation{r}
+	if err := a.setTickets(rs); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rs[0])
+}
+
+// statusFilter returns the status of the ?status= query, waiting if unset,
+// replying with an error if it isn't a reservation status.
+func statusFilter(c *gin.Context) (string, bool) {
+	status := c.Query("status")
+	if status == "" {
+		return StatusWaiting, true
+	}
+	if !validStatus(status) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "status must be one of " + StatusWaiting + ", " + StatusServed + ", " + StatusCancelled + " or " + StatusNoShow,
+			Code:    "invalid_status",
+			Fields:  []string{"status"},
+		})
+		return "", false
+	}
+	return status, true
+}
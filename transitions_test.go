@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestReservationStatus(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue"}`)
+	for i := 1; i <= 4; i++ {
+		doRequest(testApp, "POST", "/api/v1/queue/1/reservation", fmt.Sprintf(`{"name":"customer_%d","phone":"%d00000000"}`, i, i))
+	}
+	list := func(status string) []Reservation {
+		t.Helper()
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation?status="+status, "")
+		var rs []Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected reservations with status %s: %d %s", status, w.Code, w.Body.String())
+		}
+		return rs
+	}
+
+	t.Run("transitions", func(t *testing.T) {
+		w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/2/status", `{"status":"no_show"}`)
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusOK || r.Status != StatusNoShow {
+			t.Fatalf("expected the reservation marked as no show, got %d %s", w.Code, w.Body.String())
+		}
+		w = doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/2/status", `{"status":"cancelled"}`)
+		if e, _ := decodeError(w); w.Code != http.StatusConflict || e.Code != "invalid_transition" {
+			t.Fatalf("expected 409 changing a final status, got %d %s", w.Code, w.Body.String())
+		}
+		for body, code := range map[string]int{
+			`{"status":"waiting"}`: http.StatusBadRequest,
+			`{"status":"gone"}`:    http.StatusBadRequest,
+			`{}`:                   http.StatusBadRequest,
+		} {
+			if w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/3/status", body); w.Code != code {
+				t.Fatalf("expected %d for %s, got %d %s", code, body, w.Code, w.Body.String())
+			}
+		}
+		if w := doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/9/status", `{"status":"cancelled"}`); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for a missing reservation, got %d %s", w.Code, w.Body.String())
+		}
+
+		// calling the next party keeps it as served
+		if w := doRequest(testApp, "POST", "/api/v1/queue/1/next", ""); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 calling the next party, got %d %s", w.Code, w.Body.String())
+		}
+		var status string
+		if err := testApp.db.Get(&status, "SELECT status FROM reservation WHERE id=1"); err != nil || status != StatusServed {
+			t.Fatalf("expected the called party served, got %q %v", status, err)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		waiting := list(StatusWaiting)
+		if len(waiting) != 2 || waiting[0].ID != 3 || waiting[0].GroupPosition != 1 || waiting[1].ID != 4 {
+			t.Fatalf("expected the waiting parties renumbered, got %+v", waiting)
+		}
+		if all := list(""); len(all) != 2 {
+			t.Fatalf("expected the waiting parties by default, got %+v", all)
+		}
+		if served := list(StatusServed); len(served) != 1 || served[0].ID != 1 || served[0].Status != StatusServed {
+			t.Fatalf("expected the served party, got %+v", served)
+		}
+		if noShow := list(StatusNoShow); len(noShow) != 1 || noShow[0].ID != 2 {
+			t.Fatalf("expected the no show, got %+v", noShow)
+		}
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation?status=gone", "")
+		if e, _ := decodeError(w); w.Code != http.StatusBadRequest || e.Code != "invalid_status" {
+			t.Fatalf("expected 400 for an unknown status, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestGetLeftReservation(t *testing.T) {
+	testApp := newTestApp(t)
+	doRequest(testApp, "POST", "/api/v1/queue", `{"name":"dinner_queue","ticket_prefix":"A"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_1","phone":"111111111"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/reservation", `{"name":"customer_2","phone":"222222222"}`)
+	doRequest(testApp, "POST", "/api/v1/queue/1/next", "")
+	doRequest(testApp, "PATCH", "/api/v1/queue/1/reservation/2/status", `{"status":"cancelled"}`)
+
+	for rsvp, status := range map[string]string{"1": StatusServed, "2": StatusCancelled} {
+		w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/"+rsvp, "")
+		var r Reservation
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusOK || r.Status != status || r.Ticket == "" {
+			t.Fatalf("expected reservation %s %s, got %d %s", rsvp, status, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(testApp, "GET", "/api/v1/queue/1/reservation/3", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown reservation, got %d", w.Code)
+	}
+}
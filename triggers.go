@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventPositionReached is posted to the webhook of a position trigger
+const EventPositionReached = "position_reached"
+
+// PositionTrigger posts to Webhook, only once, when the reservation reaches
+// Position or better
+type PositionTrigger struct {
+	ID            int64     `json:"id"`
+	ReservationID int64     `json:"reservationid"`
+	Position      int64     `json:"position" binding:"min=0"`
+	Webhook       string    `json:"webhook" binding:"required"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// PositionReached is the body posted to the webhook of a position trigger
+type PositionReached struct {
+	Type          string    `json:"type"`
+	QueueID       int64     `json:"queueid"`
+	ReservationID int64     `json:"reservationid"`
+	Position      int64     `json:"position"`
+	Time          time.Time `json:"time"`
+}
+
+var triggerClient = &http.Client{Timeout: 10 * time.Second}
+
+// checkWebhookURL returns why raw can't be a webhook, it must be an absolute
+// http or https URL without credentials.
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("webhook can not include credentials")
+	}
+	return nil
+}
+
+// createPositionTrigger registers the trigger of the reservation, replacing
+// the previous one, the position is in the configured base.
+func (a *App) createPositionTrigger(c *gin.Context) {
+	id := c.Param("id")
+	rsvp := c.Param("rsvp")
+	var t PositionTrigger
+	if !a.bindJSON(c, &t) {
+		return
+	}
+	// stored 1-based as the positions
+	t.Position = t.Position - a.positionBase + 1
+	if t.Position < 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: "position must be at least " + strconv.FormatInt(a.positionBase, 10),
+			Code:    "invalid_request",
+			Fields:  []string{"position"},
+		})
+		return
+	}
+	if err := checkWebhookURL(t.Webhook); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apiError{
+			Message: err.Error(),
+			Code:    "invalid_webhook",
+			Fields:  []string{"webhook"},
+		})
+		return
+	}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	defer a.rollback(tx)
+	var r Reservation
+	err = tx.Get(&r, "SELECT * FROM reservation WHERE queueid=$1 AND id=$2 AND status='waiting'", id, rsvp)
+	if err == sql.ErrNoRows {
+		abortWithError(c, http.StatusNotFound, "reservation_not_found", "reservation not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	t.ReservationID = r.ID
+	t.CreatedAt = a.now().UTC()
+	res, err := tx.NamedExec(`INSERT OR REPLACE INTO position_trigger (reservationid, position, webhook, created_at)
+		VALUES (:reservationid, :position, :webhook, :created_at)`, t)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.audit(tx, r.QueueID, r.ID, "trigger_created", gin.H{"position": t.Position, "webhook": t.Webhook}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if err := a.commit(tx); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	// it may be there already
+	if err := a.checkTriggers(r.QueueID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	t.Position = a.reportPosition(t.Position)
+	c.IndentedJSON(http.StatusCreated, t)
+}
+
+// checkTriggers fires the triggers of the reservations of the queue at or
+// better than their position. A trigger is deleted when fired, so it fires
+// only once even if checked concurrently.
+func (a *App) checkTriggers(queueID int64) error {
+	var due []struct {
+		ID            int64  `json:"id"`
+		ReservationID int64  `json:"reservationid"`
+		Webhook       string `json:"webhook"`
+		GroupPosition int64  `json:"group_position"`
+	}
+	err := a.db.Select(&due, `SELECT t.id, t.reservationid, t.webhook, p.group_position FROM position_trigger t
+		JOIN (SELECT id, ROW_NUMBER() OVER (ORDER BY `+servingOrder+`) AS group_position
+			FROM reservation WHERE queueid=$1 AND status='waiting') p ON p.id = t.reservationid
+		WHERE p.group_position <= t.position`, queueID)
+	if err != nil {
+		return err
+	}
+	for _, t := range due {
+		res, err := a.db.Exec("DELETE FROM position_trigger WHERE id=$1", t.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			continue
+		}
+		body, err := json.Marshal(PositionReached{
+			Type:          EventPositionReached,
+			QueueID:       queueID,
+			ReservationID: t.ReservationID,
+			Position:      a.reportPosition(t.GroupPosition),
+			Time:          a.now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		go func(webhook string) {
+			resp, err := triggerClient.Post(webhook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Error posting position trigger: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}(t.Webhook)
+	}
+	return nil
+}
+
+// triggerListener checks the triggers of the queues whose parties moved
+func (a *App) triggerListener(ev Event) {
+	switch ev.Type {
+	case EventServed, EventDeleted, EventUpdated:
+	default:
+		return
+	}
+	if err := a.checkTriggers(ev.QueueID); err != nil {
+		log.Printf("Error checking the position triggers of queue %d: %v", ev.QueueID, err)
+	}
+}
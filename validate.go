@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ItemError is a problem found validating an item of a batch
+type ItemError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Limit is the maximum exceeded, if any
+	Limit int `json:"limit,omitempty"`
+}
+
+// ItemReport is the result of validating an item of a batch
+type ItemReport struct {
+	Index  int         `json:"index"`
+	Valid  bool        `json:"valid"`
+	Errors []ItemError `json:"errors,omitempty"`
+}
+
+// ValidationReport is the result of validating a batch of reservations
+type ValidationReport struct {
+	Valid   int          `json:"valid"`
+	Invalid int          `json:"invalid"`
+	Items   []ItemReport `json:"items"`
+}
+
+// validateReservations checks a JSON array of reservations as creating them
+// would, reporting the errors of each item without touching the database.
+func (a *App) validateReservations(c *gin.Context) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&items); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", "the body must be a JSON array of reservations")
+		return
+	}
+	report := ValidationReport{Items: []ItemReport{}}
+	for i, item := range items {
+		ir := ItemReport{Index: i, Errors: a.validateReservation(item)}
+		ir.Valid = len(ir.Errors) == 0
+		if ir.Valid {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+		report.Items = append(report.Items, ir)
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+func (a *App) validateReservation(item json.RawMessage) []ItemError {
+	var r Reservation
+	if err := json.Unmarshal(item, &r); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return []ItemError{{Field: typeErr.Field, Code: "invalid_type", Message: err.Error()}}
+		}
+		return []ItemError{{Code: "invalid_json", Message: err.Error()}}
+	}
+	var errs []ItemError
+	if a.strictBinding {
+		for _, f := range unknownFields(item, &r) {
+			errs = append(errs, ItemError{Field: f, Code: "unknown_field", Message: "unknown field " + f})
+		}
+	}
+	if r.GroupSize == 0 {
+		r.GroupSize = 1
+	}
+	err := binding.Validator.ValidateStruct(&r)
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		t := reflect.TypeOf(r)
+		for _, fe := range verrs {
+			field := fe.StructField()
+			if sf, ok := t.FieldByName(field); ok {
+				field = strings.Split(sf.Tag.Get("json"), ",")[0]
+			}
+			errs = append(errs, ItemError{Field: field, Code: fe.Tag(), Message: fe.Error()})
+		}
+	} else if err != nil {
+		errs = append(errs, ItemError{Code: "invalid", Message: err.Error()})
+	}
+	if e := a.checkLengths(r.Name, r.Phone); e != nil {
+		errs = append(errs, ItemError{Field: e.Field, Code: e.Field + "_too_long", Message: e.Error(), Limit: e.Limit})
+	} else if _, err := a.canonicalPhone(r.Phone); err != nil {
+		errs = append(errs, ItemError{Field: "phone", Code: "invalid_phone", Message: err.Error()})
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestValidateReservations(t *testing.T) {
+	testApp := newTestApp(t)
+	testApp.strictBinding = true
+
+	body := `[
+		{"name":"customer_1","phone":"111111111","groupsize":2},
+		{"name":"short","phone":"123"},
+		{"name":"customer_3","phone":"333333333","colour":"red"},
+		{"name":"customer_4","phone":444444444},
+		{"phone":"555555555","notify_lead_seconds":-1}
+	]`
+	w := doRequest(testApp, "POST", "/api/v1/reservation/validate", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var report ValidationReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid != 1 || report.Invalid != 4 || len(report.Items) != 5 {
+		t.Fatalf("unexpected report %s", w.Body.String())
+	}
+	expected := [][]ItemError{
+		nil,
+		{{Field: "name", Code: "min"}, {Field: "phone", Code: "min"}},
+		{{Field: "colour", Code: "unknown_field"}},
+		{{Field: "phone", Code: "invalid_type"}},
+		{{Field: "notify_lead_seconds", Code: "min"}},
+	}
+	for i, item := range report.Items {
+		if item.Index != i || item.Valid != (expected[i] == nil) || len(item.Errors) != len(expected[i]) {
+			t.Fatalf("unexpected item %d: %+v", i, item)
+		}
+		for j, e := range item.Errors {
+			if e.Field != expected[i][j].Field || e.Code != expected[i][j].Code || e.Message == "" {
+				t.Fatalf("unexpected error %d of item %d: %+v", j, i, e)
+			}
+		}
+	}
+
+	// nothing is stored
+	var n int
+	if err := testApp.db.Get(&n, "SELECT COUNT(*) FROM reservation"); err != nil || n != 0 {
+		t.Fatalf("expected no reservation to be created, got %d %v", n, err)
+	}
+	if w := doRequest(testApp, "POST", "/api/v1/reservation/validate", `{"name":"customer_1"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a body that isn't an array, got %d", w.Code)
+	}
+}